@@ -0,0 +1,158 @@
+package webx
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	AuthBearer = "Bearer"
+	AuthDigest = "Digest"
+)
+
+// Credentials - источник значения заголовка Authorization.
+// При отказе в доступе вызывается с refresh = true, после чего запрос повторяется один раз
+type Credentials interface {
+	Authorization(ctx context.Context, refresh bool) (string, error)
+}
+
+type CredentialsFunc func(ctx context.Context, refresh bool) (string, error)
+
+func (f CredentialsFunc) Authorization(ctx context.Context, refresh bool) (string, error) {
+	return f(ctx, refresh)
+}
+
+// authorizer - способ авторизации, применяется к каждому собранному запросу
+type authorizer interface {
+	Authorize(req *http.Request) error
+}
+
+// challenger - авторизация, способная ответить на 401 и подготовить повтор запроса
+type challenger interface {
+	Challenge(req *http.Request, res Response) (bool, error)
+}
+
+func (c v1Request) authorizer(opts *options) authorizer {
+	if opts.auth != nil {
+		return opts.auth
+	}
+
+	return c.opts.auth
+}
+
+// challenge - при отказе в доступе обновляем авторизацию и повторяем запрос один раз
+func challenge(ch challenger, next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (res Response, err error) {
+		var again *http.Request
+
+		if res, err = next.Do(req); res == nil || res.Code() != http.StatusUnauthorized {
+			return res, err
+		}
+
+		if again, err = replay(req); err != nil {
+			return res, res.Error()
+		}
+
+		if ok, cerr := ch.Challenge(again, res); cerr != nil {
+			return res, ErrResponse.WithReason(cerr)
+		} else if !ok {
+			return res, res.Error()
+		}
+
+		res.Close()
+		return next.Do(again)
+	})
+}
+
+type basicAuth struct {
+	user string
+	pass string
+}
+
+func (a *basicAuth) Authorize(req *http.Request) error {
+	req.SetBasicAuth(a.user, a.pass)
+	return nil
+}
+
+type tokenAuth struct {
+	value string
+}
+
+func (a *tokenAuth) Authorize(req *http.Request) error {
+	req.Header.Set(HeaderAuthorization, a.value)
+	return nil
+}
+
+type credsAuth struct {
+	creds Credentials
+}
+
+func (a *credsAuth) Authorize(req *http.Request) (err error) {
+	var value string
+
+	if value, err = a.creds.Authorization(req.Context(), false); err != nil {
+		return ErrCredentials.WithReason(err)
+	}
+
+	req.Header.Set(HeaderAuthorization, value)
+	return nil
+}
+
+func (a *credsAuth) Challenge(req *http.Request, res Response) (_ bool, err error) {
+	var value string
+
+	if value, err = a.creds.Authorization(req.Context(), true); err != nil {
+		return false, ErrCredentials.WithReason(err)
+	}
+
+	req.Header.Set(HeaderAuthorization, value)
+	return true, nil
+}
+
+func Bearer(token string) Option {
+	return func(o *options) error {
+		if token == "" {
+			return ErrBadOption.WithStack()
+		}
+
+		o.auth = &tokenAuth{value: AuthBearer + " " + token}
+		return nil
+	}
+}
+
+func AuthProvider(creds Credentials) Option {
+	return func(o *options) error {
+		if creds == nil {
+			return ErrBadOption.WithStack()
+		}
+
+		o.auth = &credsAuth{creds: creds}
+		return nil
+	}
+}
+
+func APIKey(value string) Option {
+	return APIKeyHeader(HeaderXAPIKey, value)
+}
+
+func APIKeyHeader(name, value string) Option {
+	return func(o *options) error {
+		if name == "" || value == "" {
+			return ErrBadOption.WithStack()
+		}
+
+		o.sethead.Set(name, value)
+		return nil
+	}
+}
+
+func APIKeyQuery(name, value string) Option {
+	return func(o *options) error {
+		if name == "" || value == "" {
+			return ErrBadOption.WithStack()
+		}
+
+		o.setget.Set(name, value)
+		return nil
+	}
+}
@@ -0,0 +1,257 @@
+package webx
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
+
+const (
+	RoundRobin Strategy = iota
+	Random
+	LeastInFlight
+	PrimaryFallback
+)
+
+const (
+	defEjectAfter = 3
+	defEjectPause = 30 * time.Second
+)
+
+var ErrMsgNoEndpoints = "Не указано ни одного адреса"
+
+// Strategy - способ выбора адреса из нескольких
+type Strategy int
+
+func newBalancerV1(bases []string, strategy Strategy, args []Option) (req *v1Request, err error) {
+	if len(bases) == 0 {
+		return nil, ErrBadURL.WithDetail(ErrMsgNoEndpoints)
+	}
+
+	if strategy < RoundRobin || strategy > PrimaryFallback {
+		return nil, ErrBadRequest.WithReason(ErrBadOption.WithStack())
+	}
+
+	if req, err = newRequestV1(bases[0], args); err != nil {
+		return nil, err
+	}
+
+	req.pool = &balancer{
+		strategy: strategy,
+		after:    req.opts.ejectAfter,
+		pause:    req.opts.ejectPause,
+		list:     make([]*endpoint, len(bases)),
+	}
+
+	if req.pool.after == 0 {
+		req.pool.after = defEjectAfter
+	}
+
+	if req.pool.pause == 0 {
+		req.pool.pause = defEjectPause
+	}
+
+	for i := range bases {
+		ep := new(endpoint)
+
+		// У каждого адреса свой автомат защиты, иначе один сбойный адрес отключит все
+		if req.opts.breaker != nil {
+			ep.breaker = newBreaker(req.opts.breaker.cfg)
+		}
+
+		if ep.base, err = parseBase(bases[i]); err != nil {
+			return nil, err
+		}
+
+		req.pool.list[i] = ep
+	}
+
+	return req, nil
+}
+
+// Eject - после after неудач подряд адрес исключается из выбора на время pause; только для базового запроса
+func Eject(after int, pause time.Duration) Option {
+	return func(o *options) error {
+		if after < 1 || pause <= 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		o.ejectAfter = after
+		o.ejectPause = pause
+		return nil
+	}
+}
+
+// Idempotent - запрос можно безопасно повторить, даже если метод этого не гарантирует
+func Idempotent() Option {
+	return func(o *options) error {
+		o.idempotent = true
+		return nil
+	}
+}
+
+// failover - при неудаче идемпотентный запрос повторяется на другом адресе
+func (c v1Request) failover(req *http.Request, ref string, opts *options) (res Response, err error) {
+	used := make(map[*endpoint]bool, len(c.pool.list))
+
+	for {
+		ep := c.pool.Pick(used)
+		used[ep] = true
+
+		// У каждого адреса могут быть свои базовые аргументы
+		if req.URL, err = c.resolve(ep.base, ref, opts); err != nil {
+			return nil, ErrBadRequest.WithReason(err)
+		}
+
+		if err = c.applyGetArgs(req, opts); err != nil {
+			return nil, ErrBadRequest.WithReason(err)
+		}
+
+		req.Host = ""
+		cur := c
+		cur.base = ep.base
+
+		if ep.breaker != nil {
+			cur.opts.breaker = ep.breaker
+		}
+
+		c.pool.Enter(ep)
+		res, err = cur.do(req, opts)
+		c.pool.Leave(ep)
+
+		code := 0
+		if res != nil {
+			code = res.Code()
+		}
+
+		ok := !defIsFailure(code, err)
+
+		// Отказ на нашей стороне ничего не говорит о самом адресе
+		if !localFailure(err) {
+			c.pool.Report(ep, ok, time.Now())
+		}
+
+		if ok || len(used) >= len(c.pool.list) || !idempotent(req, opts) || req.Context().Err() != nil {
+			return res, err
+		}
+
+		// Если тело не повторить - остается последний результат
+		next, rerr := replay(req)
+		if rerr != nil {
+			return res, err
+		}
+
+		if res != nil {
+			res.Close()
+		}
+
+		req = next
+	}
+}
+
+// localFailure - запрос не дошел до адреса из-за собственных ограничений клиента
+func localFailure(err error) bool {
+	return err != nil && (errx.Is(err, ErrCircuitOpen) || errx.Is(err, ErrQueueFull) || errx.Is(err, ErrQueueWait) || errx.Is(err, ErrRateLimit))
+}
+
+func idempotent(req *http.Request, opts *options) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return opts.idempotent
+}
+
+// balancer - набор адресов с пассивным отслеживанием их состояния
+type balancer struct {
+	sync.Mutex
+
+	strategy Strategy
+	after    int
+	pause    time.Duration
+	list     []*endpoint
+	next     int
+}
+
+type endpoint struct {
+	base    *url.URL
+	active  int
+	fails   int
+	until   time.Time
+	breaker *breaker
+}
+
+// Pick - выбор среди еще не использованных адресов, исключенные только если других нет
+func (b *balancer) Pick(used map[*endpoint]bool) *endpoint {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	list := make([]*endpoint, 0, len(b.list))
+
+	for _, ep := range b.list {
+		if !used[ep] && !now.Before(ep.until) {
+			list = append(list, ep)
+		}
+	}
+
+	if len(list) == 0 {
+		// Все исключены - берем тот, что вернется раньше других
+		for _, ep := range b.list {
+			if !used[ep] && (len(list) == 0 || ep.until.Before(list[0].until)) {
+				list = append(list[:0], ep)
+			}
+		}
+	}
+
+	switch b.strategy {
+	case Random:
+		return list[rand.Intn(len(list))]
+	case LeastInFlight:
+		best := list[0]
+		for _, ep := range list[1:] {
+			if ep.active < best.active {
+				best = ep
+			}
+		}
+		return best
+	case PrimaryFallback:
+		return list[0]
+	}
+
+	b.next++
+	return list[b.next%len(list)]
+}
+
+func (b *balancer) Enter(ep *endpoint) {
+	b.Lock()
+	defer b.Unlock()
+	ep.active++
+}
+
+func (b *balancer) Leave(ep *endpoint) {
+	b.Lock()
+	defer b.Unlock()
+	ep.active--
+}
+
+// Report - учет неудач подряд, после after неудач адрес исключается на время pause
+func (b *balancer) Report(ep *endpoint, ok bool, now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	if ok {
+		ep.fails = 0
+		return
+	}
+
+	if ep.fails++; ep.fails >= b.after {
+		ep.fails = 0
+		ep.until = now.Add(b.pause)
+	}
+}
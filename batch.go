@@ -0,0 +1,165 @@
+package webx
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/shestakovda/errx"
+)
+
+const (
+	CollectAll BatchMode = iota
+	FailFast
+)
+
+var ErrMsgBatchFailed = "Ошибкой завершились %d из %d заданий"
+var ErrMsgJobSkipped = "Задание не выполнялось, пакет прерван предыдущей ошибкой"
+
+// BatchMode - продолжать ли пакет после первой ошибки
+type BatchMode int
+
+// Job - один вызов Make в пакете
+type Job struct {
+	Ref     string
+	Options []Option
+}
+
+// Result - итог задания, в том же порядке, что и задания
+type Result struct {
+	Response Response
+	Err      error
+}
+
+// Batch - выполнение заданий не больше чем parallel одновременно
+// В режиме FailFast после первой ошибки задания в пути отменяются, а оставшиеся не запускаются
+func Batch(req Request, jobs []Job, parallel int, mode BatchMode) ([]Result, error) {
+	if req == nil || parallel < 1 || mode < CollectAll || mode > FailFast {
+		return nil, ErrBadOption.WithStack()
+	}
+
+	var wg sync.WaitGroup
+
+	b := &batch{
+		req:    req,
+		mode:   mode,
+		list:   make([]Result, len(jobs)),
+		active: make(map[int]context.CancelFunc, parallel),
+	}
+
+	next := make(chan int)
+
+	for w := 0; w < parallel && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				b.run(i, jobs[i])
+			}
+		}()
+	}
+
+	for i := range jobs {
+		next <- i
+	}
+
+	close(next)
+	wg.Wait()
+
+	return b.list, b.Err()
+}
+
+type batch struct {
+	sync.Mutex
+
+	req    Request
+	mode   BatchMode
+	list   []Result
+	active map[int]context.CancelFunc
+	failed bool
+	cause  error
+}
+
+// run - отмена пакета доходит до запроса через его собственный контекст
+func (b *batch) run(i int, job Job) {
+	var opts options
+
+	if opts, b.list[i].Err = getOpts(job.Options); b.list[i].Err != nil {
+		b.list[i].Err = ErrBadRequest.WithReason(b.list[i].Err)
+		b.done(i, b.list[i].Err)
+		return
+	}
+
+	if opts.ctx == nil {
+		opts.ctx = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(opts.ctx)
+
+	if !b.start(i, cancel) {
+		cancel()
+		b.list[i].Err = ErrBatch.WithDetail(ErrMsgJobSkipped)
+		return
+	}
+
+	args := append(job.Options[:len(job.Options):len(job.Options)], Context(ctx))
+	res, err := b.req.Make(job.Ref, args...)
+	b.list[i] = Result{Response: res, Err: err}
+
+	b.done(i, err)
+	releaseOnClose(res, cancel)
+}
+
+func (b *batch) start(i int, cancel context.CancelFunc) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.failed {
+		return false
+	}
+
+	b.active[i] = cancel
+	return true
+}
+
+func (b *batch) done(i int, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.active, i)
+
+	if err == nil {
+		return
+	}
+
+	if b.cause == nil {
+		b.cause = err
+	}
+
+	if b.mode != FailFast || b.failed {
+		return
+	}
+
+	// Задания в пути больше не нужны
+	b.failed = true
+	for _, cancel := range b.active {
+		cancel()
+	}
+}
+
+// Err - общая ошибка пакета, причиной служит первая по времени ошибка задания
+func (b *batch) Err() error {
+	if b.cause == nil {
+		return nil
+	}
+
+	debug := make(errx.Debug)
+
+	for i := range b.list {
+		if b.list[i].Err != nil {
+			debug["Задание "+strconv.Itoa(i)] = b.list[i].Err.Error()
+		}
+	}
+
+	return ErrBatch.WithReason(b.cause).WithDetail(ErrMsgBatchFailed, len(debug), len(b.list)).WithDebug(debug)
+}
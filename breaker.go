@@ -0,0 +1,267 @@
+package webx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+const (
+	defBreakerRatio    = 0.5
+	defBreakerMin      = 10
+	defBreakerWindow   = 10 * time.Second
+	defBreakerCooldown = 30 * time.Second
+	breakerBuckets     = 10
+)
+
+type BreakerState int
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig - параметры автомата защиты, нулевые значения заменяются умолчаниями
+type BreakerConfig struct {
+	// FailureRatio - доля неудачных запросов в окне, при которой цепь размыкается
+	FailureRatio float64
+
+	// MinRequests - меньше запросов в окне недостаточно для решения
+	MinRequests int
+
+	// Window - окно подсчета неудач, не короче 10 наносекунд
+	Window time.Duration
+
+	// Cooldown - сколько цепь остается разомкнутой до пробных запросов
+	Cooldown time.Duration
+
+	// Probes - сколько пробных запросов должно пройти успешно, чтобы цепь замкнулась
+	Probes int
+
+	// IsFailure - считать ли результат неудачей, по умолчанию сетевые ошибки и 5xx
+	IsFailure func(code int, err error) bool
+
+	// OnChange - уведомление о смене состояния
+	OnChange func(from, to BreakerState)
+}
+
+// CircuitBreaker - автомат защиты, задается только для базового запроса
+func CircuitBreaker(cfg BreakerConfig) Option {
+	return func(o *options) error {
+		if cfg.FailureRatio < 0 || cfg.FailureRatio > 1 || cfg.MinRequests < 0 || cfg.Window < 0 || cfg.Cooldown < 0 || cfg.Probes < 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		// Иначе у окна не будет ни одной непустой части
+		if cfg.Window > 0 && cfg.Window < breakerBuckets {
+			return ErrBadOption.WithStack()
+		}
+
+		o.breaker = newBreaker(cfg)
+		return nil
+	}
+}
+
+// guarded - пока цепь разомкнута, запрос не выполняется вовсе
+func guarded(b *breaker, next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (res Response, err error) {
+		var ok bool
+		var gen uint64
+		var code int
+
+		if gen, ok = b.Allow(time.Now()); !ok {
+			return nil, ErrCircuitOpen.WithDebug(errx.Debug{
+				"URL":    req.URL.String(),
+				"Method": req.Method,
+			})
+		}
+
+		if res, err = next.Do(req); res != nil {
+			code = res.Code()
+		}
+
+		b.Report(gen, !b.cfg.IsFailure(code, err), time.Now())
+		return res, err
+	})
+}
+
+func newBreaker(cfg BreakerConfig) *breaker {
+	if cfg.FailureRatio == 0 {
+		cfg.FailureRatio = defBreakerRatio
+	}
+
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = defBreakerMin
+	}
+
+	if cfg.Window == 0 {
+		cfg.Window = defBreakerWindow
+	}
+
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = defBreakerCooldown
+	}
+
+	if cfg.Probes == 0 {
+		cfg.Probes = 1
+	}
+
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = defIsFailure
+	}
+
+	return &breaker{
+		cfg:  cfg,
+		size: cfg.Window / breakerBuckets,
+	}
+}
+
+// breaker - автомат защиты, общий для всех запросов базового запроса
+type breaker struct {
+	sync.Mutex
+
+	cfg    BreakerConfig
+	gen    uint64
+	size   time.Duration
+	state  BreakerState
+	opened time.Time
+	probes int
+	passed int
+	window [breakerBuckets]breakerBucket
+}
+
+type breakerBucket struct {
+	slot  int64
+	total int
+	fails int
+}
+
+// Allow - можно ли выполнять запрос, и к какому поколению состояния он относится
+func (b *breaker) Allow(now time.Time) (uint64, bool) {
+	var from BreakerState
+
+	b.Lock()
+	from = b.state
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.opened) < b.cfg.Cooldown {
+			b.Unlock()
+			return 0, false
+		}
+
+		b.moveTo(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.Probes {
+			b.Unlock()
+			b.notify(from, BreakerHalfOpen)
+			return 0, false
+		}
+
+		b.probes++
+	}
+
+	gen, to := b.gen, b.state
+	b.Unlock()
+
+	b.notify(from, to)
+	return gen, true
+}
+
+// Report - учет результата, устаревшие результаты прошлых состояний не учитываются
+func (b *breaker) Report(gen uint64, ok bool, now time.Time) {
+	b.Lock()
+	from := b.state
+
+	if gen != b.gen {
+		b.Unlock()
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		total, fails := b.record(ok, now)
+
+		if total >= b.cfg.MinRequests && float64(fails) >= b.cfg.FailureRatio*float64(total) {
+			b.moveTo(BreakerOpen)
+			b.opened = now
+		}
+	case BreakerHalfOpen:
+		if !ok {
+			b.moveTo(BreakerOpen)
+			b.opened = now
+		} else if b.passed++; b.passed >= b.cfg.Probes {
+			b.moveTo(BreakerClosed)
+		}
+	}
+
+	to := b.state
+	b.Unlock()
+
+	b.notify(from, to)
+}
+
+// record - добавление результата в скользящее окно и итог по окну
+func (b *breaker) record(ok bool, now time.Time) (total, fails int) {
+	slot := now.UnixNano() / int64(b.size)
+	cur := &b.window[slot%breakerBuckets]
+
+	if cur.slot != slot {
+		*cur = breakerBucket{slot: slot}
+	}
+
+	cur.total++
+	if !ok {
+		cur.fails++
+	}
+
+	for i := range b.window {
+		if slot-b.window[i].slot < breakerBuckets {
+			total += b.window[i].total
+			fails += b.window[i].fails
+		}
+	}
+
+	return total, fails
+}
+
+// moveTo - смена состояния сбрасывает счетчики и делает прошлые результаты устаревшими
+func (b *breaker) moveTo(state BreakerState) {
+	b.gen++
+	b.state = state
+	b.probes = 0
+	b.passed = 0
+	b.window = [breakerBuckets]breakerBucket{}
+}
+
+func (b *breaker) notify(from, to BreakerState) {
+	if from != to && b.cfg.OnChange != nil {
+		b.cfg.OnChange(from, to)
+	}
+}
+
+func defIsFailure(code int, err error) bool {
+	if code == 0 {
+		return err != nil && !errors.Is(err, context.Canceled)
+	}
+
+	return code >= http.StatusInternalServerError
+}
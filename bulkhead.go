@@ -0,0 +1,131 @@
+package webx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
+
+// MaxInFlight - не больше n одновременных запросов, остальные ждут в очереди; только для базового запроса
+// Размер очереди queue и время ожидания wait не ограничены, если равны 0; при queue < 0 ожидания нет вовсе
+func MaxInFlight(n, queue int, wait time.Duration) Option {
+	return func(o *options) error {
+		if n < 1 || wait < 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		o.bulkhead = newBulkhead(n, queue, wait)
+		return nil
+	}
+}
+
+// isolated - место занимается на весь вызов, а для потока - до его закрытия
+func isolated(b *bulkhead, next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (res Response, err error) {
+		if exc := b.Acquire(req.Context()); exc != nil {
+			return nil, exc.WithDebug(errx.Debug{
+				"URL":    req.URL.String(),
+				"Method": req.Method,
+			})
+		}
+
+		res, err = next.Do(req)
+		releaseOnClose(res, b.Release)
+		return res, err
+	})
+}
+
+func newBulkhead(n, queue int, wait time.Duration) *bulkhead {
+	return &bulkhead{
+		slots: make(chan struct{}, n),
+		queue: queue,
+		wait:  wait,
+	}
+}
+
+// bulkhead - ограничение одновременных запросов, общее для всех запросов базового запроса
+type bulkhead struct {
+	sync.Mutex
+
+	slots   chan struct{}
+	queue   int
+	wait    time.Duration
+	waiting int
+}
+
+func (b *bulkhead) Acquire(ctx context.Context) errx.Error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if err := b.enqueue(); err != nil {
+		return err
+	}
+
+	defer b.dequeue()
+
+	var timeout <-chan time.Time
+
+	if b.wait > 0 {
+		timer := time.NewTimer(b.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrQueueWait.WithStack()
+	case <-ctx.Done():
+		return ErrQueueWait.WithReason(ctx.Err())
+	}
+}
+
+func (b *bulkhead) Release() { <-b.slots }
+
+func (b *bulkhead) enqueue() errx.Error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.queue < 0 || (b.queue > 0 && b.waiting >= b.queue) {
+		return ErrQueueFull.WithStack()
+	}
+
+	b.waiting++
+	return nil
+}
+
+func (b *bulkhead) dequeue() {
+	b.Lock()
+	defer b.Unlock()
+	b.waiting--
+}
+
+// releaseOnClose - у потока ответа освобождение откладывается до его закрытия
+func releaseOnClose(res Response, release func()) {
+	if r, ok := res.(*v1Response); ok && r.stream != nil {
+		r.stream = &released{ReadCloser: r.stream, release: release}
+		return
+	}
+
+	release()
+}
+
+// released - поток ответа, при закрытии которого освобождается место
+type released struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *released) Close() error {
+	defer r.once.Do(r.release)
+	return r.ReadCloser.Close()
+}
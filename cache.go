@@ -0,0 +1,488 @@
+package webx
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
+
+// maxHeuristic - предел срока свежести, вычисленного по Last-Modified
+const maxHeuristic = 24 * time.Hour
+
+// CacheEntry - сохраненный ответ вместе с условиями его повторного использования
+type CacheEntry struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+
+	// Vary - значения заголовков запроса, от которых зависит ответ
+	Vary http.Header
+
+	// Stored - время получения ответа
+	Stored time.Time
+}
+
+// CacheStore - хранилище ответов. Отсутствие записи - nil без ошибки
+type CacheStore interface {
+	Get(key string) (*CacheEntry, error)
+	Set(key string, entry *CacheEntry) error
+	Delete(key string) error
+}
+
+// Cache - кеширование ответов на GET-запросы по правилам RFC 9111
+func Cache(store CacheStore) Option {
+	return func(o *options) error {
+		if store == nil {
+			return ErrBadOption.WithStack()
+		}
+
+		o.cache = store
+		return nil
+	}
+}
+
+func (c v1Request) cacheStore(opts *options) CacheStore {
+	if opts.cache != nil {
+		return opts.cache
+	}
+
+	return c.opts.cache
+}
+
+// cached - ответ из кеша, если он свежий, иначе запрос с проверкой актуальности
+func cached(store CacheStore, ro responseOpts, next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (res Response, err error) {
+		reqcc := parseCacheControl(req.Header)
+
+		// Условия, поставленные клиентом, он обрабатывает сам
+		if _, ok := reqcc["no-store"]; ok || req.Header.Get(HeaderIfNoneMatch) != "" || req.Header.Get(HeaderIfModSince) != "" {
+			return next.Do(req)
+		}
+
+		key := cacheKey(req)
+		entry, _ := store.Get(key)
+
+		if entry != nil && !entry.Matches(req) {
+			entry = nil
+		}
+
+		if entry != nil {
+			_, force := reqcc["no-cache"]
+			if age, ok := reqcc["max-age"]; ok && age == "0" {
+				force = true
+			}
+
+			if !force && entry.Fresh(time.Now()) {
+				return newCachedResponse(req, entry, ro)
+			}
+
+			// Устаревший ответ можно проверить, если есть чем
+			if etag, mod := entry.Header.Get(HeaderETag), entry.Header.Get(HeaderLastModified); etag != "" || mod != "" {
+				req = req.Clone(req.Context())
+
+				if etag != "" {
+					req.Header.Set(HeaderIfNoneMatch, etag)
+				}
+
+				if mod != "" {
+					req.Header.Set(HeaderIfModSince, mod)
+				}
+			} else {
+				entry = nil
+			}
+		}
+
+		if res, err = next.Do(req); res == nil {
+			return res, err
+		}
+
+		// Ответ не изменился - обновляем заголовки и отдаем сохраненное тело
+		if entry != nil && res.Code() == http.StatusNotModified {
+			fresh := &CacheEntry{
+				Code:   entry.Code,
+				Header: entry.Header.Clone(),
+				Body:   entry.Body,
+				Vary:   entry.Vary,
+				Stored: time.Now(),
+			}
+
+			for name, values := range res.Header() {
+				if name != HeaderContentLength {
+					fresh.Header[name] = values
+				}
+			}
+
+			store.Set(key, fresh)
+			return newCachedResponse(req, fresh, ro)
+		}
+
+		// Оборванное или обрезанное тело в кеш не попадает
+		if storable(req, res) && complete(res, err) {
+			store.Set(key, newCacheEntry(req, res))
+		} else if entry != nil {
+			store.Delete(key)
+		}
+
+		return res, err
+	})
+}
+
+// cacheKey - ответы разным пользователям хранятся отдельно, сама авторизация в ключ не попадает
+func cacheKey(req *http.Request) string {
+	auth := req.Header.Get(HeaderAuthorization)
+	if auth == "" {
+		return req.URL.String()
+	}
+
+	sum := sha256.Sum256([]byte(auth))
+	return req.URL.String() + "\n" + hex.EncodeToString(sum[:])
+}
+
+// complete - тело ответа прочитано целиком, ошибка может быть только в коде ответа
+func complete(res Response, err error) bool {
+	if r, ok := res.(*v1Response); ok {
+		return !r.partial
+	}
+
+	return err == nil
+}
+
+func newCacheEntry(req *http.Request, res Response) *CacheEntry {
+	entry := &CacheEntry{
+		Code:   res.Code(),
+		Header: res.Header().Clone(),
+		Body:   append([]byte(nil), res.Body()...),
+		Vary:   make(http.Header, 2),
+		Stored: time.Now(),
+	}
+
+	for _, name := range varyNames(res.Header()) {
+		entry.Vary[name] = req.Header.Values(name)
+	}
+
+	return entry
+}
+
+func newCachedResponse(req *http.Request, entry *CacheEntry, ro responseOpts) (*v1Response, error) {
+	r := &v1Response{
+		base:     req,
+		last:     req,
+		head:     entry.Header.Clone(),
+		code:     entry.Code,
+		body:     append([]byte(nil), entry.Body...),
+		proto:    "HTTP/1.1",
+		size:     int64(len(entry.Body)),
+		cached:   true,
+		limit:    ro.limit,
+		accept:   ro.accept,
+		classify: ro.classify,
+		errBody:  ro.errBody,
+		errFresh: ro.errFresh,
+		endpoint: ro.endpoint,
+	}
+
+	r.cook = (&http.Response{Header: r.head}).Cookies()
+	return r, r.Error()
+}
+
+// storable - можно ли сохранить ответ для повторного использования
+func storable(req *http.Request, res Response) bool {
+	switch res.Code() {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+
+	cc := parseCacheControl(res.Header())
+
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+
+	for _, name := range varyNames(res.Header()) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	// Без срока свежести и без возможности проверки хранить бессмысленно
+	if _, ok := cc["max-age"]; ok {
+		return true
+	}
+
+	if _, ok := cc["no-cache"]; ok {
+		return true
+	}
+
+	head := res.Header()
+	return head.Get(HeaderExpires) != "" || head.Get(HeaderETag) != "" || head.Get(HeaderLastModified) != ""
+}
+
+// Matches - подходит ли сохраненный ответ под заголовки запроса
+func (e *CacheEntry) Matches(req *http.Request) bool {
+	for _, name := range varyNames(e.Header) {
+		if name == "*" {
+			return false
+		}
+
+		if strings.Join(e.Vary.Values(name), ",") != strings.Join(req.Header.Values(name), ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Fresh - можно ли отдать ответ без обращения к серверу
+func (e *CacheEntry) Fresh(now time.Time) bool {
+	cc := parseCacheControl(e.Header)
+
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+
+	return e.lifetime(cc) > e.age(now)
+}
+
+func (e *CacheEntry) lifetime(cc map[string]string) time.Duration {
+	if value, ok := cc["max-age"]; ok {
+		if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Duration(sec) * time.Second
+		}
+		return 0
+	}
+
+	date := e.date()
+
+	if value := e.Header.Get(HeaderExpires); value != "" {
+		if exp, err := http.ParseTime(value); err == nil {
+			return exp.Sub(date)
+		}
+		return 0
+	}
+
+	// Эвристика: десятая часть времени с последнего изменения
+	if mod, err := http.ParseTime(e.Header.Get(HeaderLastModified)); err == nil && date.After(mod) {
+		if life := date.Sub(mod) / 10; life < maxHeuristic {
+			return life
+		}
+		return maxHeuristic
+	}
+
+	return 0
+}
+
+func (e *CacheEntry) age(now time.Time) time.Duration {
+	age := e.Stored.Sub(e.date())
+	if age < 0 {
+		age = 0
+	}
+
+	if sec, err := strconv.ParseInt(e.Header.Get(HeaderAge), 10, 64); err == nil {
+		if val := time.Duration(sec) * time.Second; val > age {
+			age = val
+		}
+	}
+
+	return age + now.Sub(e.Stored)
+}
+
+func (e *CacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get(HeaderDate)); err == nil {
+		return date
+	}
+
+	return e.Stored
+}
+
+func parseCacheControl(head http.Header) map[string]string {
+	cc := make(map[string]string, 4)
+
+	for _, value := range head.Values(HeaderCacheControl) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+
+			name, arg := item, ""
+			if i := strings.IndexByte(item, '='); i >= 0 {
+				name, arg = item[:i], strings.Trim(item[i+1:], `"`)
+			}
+
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+
+	return cc
+}
+
+func varyNames(head http.Header) (list []string) {
+	for _, value := range head.Values(HeaderVary) {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				list = append(list, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return list
+}
+
+// NewMemoryCache - хранилище в памяти, вытесняющее давно не использованные ответы
+func NewMemoryCache(size int) CacheStore {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &memoryCache{
+		size:  size,
+		list:  list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+type memoryCache struct {
+	sync.Mutex
+
+	size  int
+	list  *list.List
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+func (c *memoryCache) Get(key string) (*CacheEntry, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if item, ok := c.items[key]; ok {
+		c.list.MoveToFront(item)
+		return item.Value.(*memoryItem).entry, nil
+	}
+
+	return nil, nil
+}
+
+func (c *memoryCache) Set(key string, entry *CacheEntry) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if item, ok := c.items[key]; ok {
+		item.Value.(*memoryItem).entry = entry
+		c.list.MoveToFront(item)
+		return nil
+	}
+
+	c.items[key] = c.list.PushFront(&memoryItem{key: key, entry: entry})
+
+	for c.list.Len() > c.size {
+		last := c.list.Back()
+		c.list.Remove(last)
+		delete(c.items, last.Value.(*memoryItem).key)
+	}
+
+	return nil
+}
+
+func (c *memoryCache) Delete(key string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if item, ok := c.items[key]; ok {
+		c.list.Remove(item)
+		delete(c.items, key)
+	}
+
+	return nil
+}
+
+// NewDiskCache - хранилище в каталоге, по файлу на каждый адрес
+func NewDiskCache(dir string) (_ CacheStore, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, ErrCache.WithReason(err).WithDebug(errx.Debug{
+			"Каталог": dir,
+		})
+	}
+
+	return &diskCache{dir: dir}, nil
+}
+
+type diskCache struct {
+	dir string
+}
+
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *diskCache) Get(key string) (_ *CacheEntry, err error) {
+	var buf []byte
+
+	if buf, err = ioutil.ReadFile(c.path(key)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, ErrCache.WithReason(err)
+	}
+
+	entry := new(CacheEntry)
+
+	if err = gob.NewDecoder(bytes.NewReader(buf)).Decode(entry); err != nil {
+		return nil, ErrCache.WithReason(err)
+	}
+
+	return entry, nil
+}
+
+func (c *diskCache) Set(key string, entry *CacheEntry) (err error) {
+	var tmp *os.File
+
+	if tmp, err = ioutil.TempFile(c.dir, "tmp-"); err != nil {
+		return ErrCache.WithReason(err)
+	}
+
+	// Запись через временный файл, чтобы параллельное чтение не увидело половину
+	if err = gob.NewEncoder(tmp).Encode(entry); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return ErrCache.WithReason(err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return ErrCache.WithReason(err)
+	}
+
+	if err = os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return ErrCache.WithReason(err)
+	}
+
+	return nil
+}
+
+func (c *diskCache) Delete(key string) (err error) {
+	if err = os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		return ErrCache.WithReason(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package webx
+
+import (
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shestakovda/errx"
+)
+
+// Dedup - одинаковые одновременные GET и HEAD выполняются одним запросом; только для базового запроса
+// Кроме метода, адреса и авторизации одинаковыми должны быть значения заголовков headers
+func Dedup(headers ...string) Option {
+	return func(o *options) error {
+		names := make([]string, len(headers))
+
+		for i := range headers {
+			if headers[i] = strings.TrimSpace(headers[i]); headers[i] == "" {
+				return ErrBadOption.WithStack()
+			}
+
+			names[i] = textproto.CanonicalMIMEHeaderKey(headers[i])
+		}
+
+		sort.Strings(names)
+
+		o.dedup = &flightGroup{
+			head:  names,
+			calls: make(map[string]*flight),
+		}
+		return nil
+	}
+}
+
+// deduped - первый вызов идет на сервер, остальные ждут его ответ и получают копию
+// Каждый ожидающий ограничен своим контекстом, а отмена ведущего на них не переносится
+func deduped(g *flightGroup, ro responseOpts, next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (Response, error) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return next.Do(req)
+		}
+
+		ctx := req.Context()
+		key := g.key(req, ro.limit)
+
+		for {
+			f, leader := g.Join(key)
+
+			if leader {
+				f.res, f.err = next.Do(req)
+				f.aborted = f.err != nil && ctx.Err() != nil
+				g.Leave(f)
+				return cloneResponse(f.res), f.err
+			}
+
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return nil, ErrBadRequest.WithReason(ctx.Err()).WithDebug(errx.Debug{
+					"URL":    req.URL.String(),
+					"Method": req.Method,
+				})
+			}
+
+			// Ведущий отменен своим вызовом - запрос выполняет кто-то из ожидающих
+			if !f.aborted {
+				return adopt(f, ro)
+			}
+		}
+	})
+}
+
+// flightGroup - запросы в пути, общие для всех вызовов базового запроса
+type flightGroup struct {
+	sync.Mutex
+
+	head  []string
+	calls map[string]*flight
+}
+
+type flight struct {
+	key     string
+	res     Response
+	err     error
+	done    chan struct{}
+	aborted bool
+}
+
+// key - авторизация и предел размера тела входят в ключ всегда, ответ чужому пользователю не отдается
+func (g *flightGroup) key(req *http.Request, limit int64) string {
+	var key strings.Builder
+
+	key.WriteString(req.Method)
+	key.WriteString(" ")
+	key.WriteString(req.URL.String())
+	key.WriteString("\n")
+	key.WriteString(strconv.FormatInt(limit, 10))
+	key.WriteString("\n")
+	key.WriteString(req.Header.Get(HeaderAuthorization))
+
+	for _, name := range g.head {
+		key.WriteString("\n")
+		key.WriteString(name)
+		key.WriteString(": ")
+		key.WriteString(strings.Join(req.Header[name], ", "))
+	}
+
+	return key.String()
+}
+
+func (g *flightGroup) Join(key string) (*flight, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	if f, ok := g.calls[key]; ok {
+		return f, false
+	}
+
+	f := &flight{key: key, done: make(chan struct{})}
+	g.calls[key] = f
+	return f, true
+}
+
+func (g *flightGroup) Leave(f *flight) {
+	g.Lock()
+	delete(g.calls, f.key)
+	g.Unlock()
+
+	close(f.done)
+}
+
+// adopt - копия ответа ведущего, проверенная по правилам самого ожидающего
+func adopt(f *flight, ro responseOpts) (Response, error) {
+	res := cloneResponse(f.res)
+
+	r, ok := res.(*v1Response)
+	if !ok || r.partial || (f.err != nil && !errx.Is(f.err, ErrResponse)) {
+		return res, f.err
+	}
+
+	r.accept = ro.accept
+	r.classify = ro.classify
+	r.errBody = ro.errBody
+	r.errFresh = ro.errFresh
+	return r, r.Error()
+}
+
+// cloneResponse - у каждого вызова свой ответ, который можно менять и закрывать независимо
+func cloneResponse(res Response) Response {
+	r, ok := res.(*v1Response)
+	if !ok {
+		return res
+	}
+
+	dup := *r
+	dup.head = r.head.Clone()
+	dup.body = append([]byte(nil), r.body...)
+	dup.cook = append([]*http.Cookie(nil), r.cook...)
+	return &dup
+}
@@ -0,0 +1,293 @@
+package webx
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	digestQopAuth         = "auth"
+	digestQopAuthInt      = "auth-int"
+	digestAlgorithmMD5    = "MD5"
+	digestAlgorithmSHA256 = "SHA-256"
+)
+
+// digestAlgorithms - поддерживаемые алгоритмы от самого надежного
+var digestAlgorithms = []string{"SHA-256-SESS", "SHA-256", "MD5-SESS", "MD5"}
+
+func DigestAuth(user, pass string) Option {
+	return func(o *options) error {
+		if user == "" {
+			return ErrBadOption.WithStack()
+		}
+
+		o.auth = &digestAuth{user: user, pass: pass}
+		return nil
+	}
+}
+
+// digestAuth - авторизация RFC 7616, состояние общее для всех запросов базового запроса
+type digestAuth struct {
+	sync.Mutex
+
+	user string
+	pass string
+
+	nc    uint32
+	qop   string
+	algo  string
+	realm string
+	nonce string
+	opaq  string
+}
+
+func (a *digestAuth) Authorize(req *http.Request) (err error) {
+	a.Lock()
+	defer a.Unlock()
+
+	// Пока сервер не прислал вызов - отправляем без авторизации
+	if a.nonce == "" {
+		return nil
+	}
+
+	return a.authorize(req)
+}
+
+func (a *digestAuth) Challenge(req *http.Request, res Response) (bool, error) {
+	var best int
+	var params map[string]string
+
+	// Из нескольких вызовов выбираем самый надежный из поддерживаемых алгоритм
+	for _, value := range res.Header().Values(HeaderAuthenticate) {
+		for _, ch := range parseChallenges(value) {
+			if !strings.EqualFold(ch.scheme, AuthDigest) {
+				continue
+			}
+
+			if rank := digestRank(ch.params["algorithm"]); rank > best {
+				best, params = rank, ch.params
+			}
+		}
+	}
+
+	if params == nil || params["nonce"] == "" {
+		return false, nil
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.nc = 0
+	a.realm = params["realm"]
+	a.nonce = params["nonce"]
+	a.opaq = params["opaque"]
+	a.qop = ""
+
+	if a.algo = strings.ToUpper(params["algorithm"]); a.algo == "" {
+		a.algo = digestAlgorithmMD5
+	}
+
+	if qop := params["qop"]; qop != "" {
+		for _, item := range strings.Split(qop, ",") {
+			switch item = strings.TrimSpace(item); item {
+			case digestQopAuth:
+				a.qop = item
+			case digestQopAuthInt:
+				if a.qop == "" {
+					a.qop = item
+				}
+			}
+		}
+
+		if a.qop == "" {
+			return false, nil
+		}
+	}
+
+	if err := a.authorize(req); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (a *digestAuth) authorize(req *http.Request) (err error) {
+	var body []byte
+	var cnonce string
+
+	uri := req.URL.RequestURI()
+	sess := strings.HasSuffix(a.algo, "-SESS")
+
+	newHash := md5.New
+	if strings.HasPrefix(a.algo, digestAlgorithmSHA256) {
+		newHash = sha256.New
+	}
+
+	if cnonce, err = newCnonce(); err != nil {
+		return ErrCredentials.WithReason(err)
+	}
+
+	a.nc++
+	nc := fmt.Sprintf("%08x", a.nc)
+
+	ha1 := digestHash(newHash, a.user+":"+a.realm+":"+a.pass)
+	if sess {
+		ha1 = digestHash(newHash, ha1+":"+a.nonce+":"+cnonce)
+	}
+
+	a2 := req.Method + ":" + uri
+	if a.qop == digestQopAuthInt {
+		// Целостность тела требует его хеша, тело читается из повторяемого источника
+		if body, err = requestBody(req); err != nil {
+			return err
+		}
+		a2 += ":" + digestHash(newHash, string(body))
+	}
+	ha2 := digestHash(newHash, a2)
+
+	var resp string
+	if a.qop == "" {
+		resp = digestHash(newHash, ha1+":"+a.nonce+":"+ha2)
+	} else {
+		resp = digestHash(newHash, ha1+":"+a.nonce+":"+nc+":"+cnonce+":"+a.qop+":"+ha2)
+	}
+
+	buf := new(strings.Builder)
+	fmt.Fprintf(buf, `%s username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s"`,
+		AuthDigest, escQuotes(a.user), escQuotes(a.realm), escQuotes(a.nonce), escQuotes(uri), a.algo, resp)
+
+	if a.qop != "" {
+		fmt.Fprintf(buf, `, qop=%s, nc=%s, cnonce="%s"`, a.qop, nc, cnonce)
+	}
+
+	if a.opaq != "" {
+		fmt.Fprintf(buf, `, opaque="%s"`, escQuotes(a.opaq))
+	}
+
+	req.Header.Set(HeaderAuthorization, buf.String())
+	return nil
+}
+
+// digestRank - надежность алгоритма, 0 для неподдерживаемых
+func digestRank(algo string) int {
+	if algo == "" {
+		algo = digestAlgorithmMD5
+	}
+
+	for i := range digestAlgorithms {
+		if strings.EqualFold(digestAlgorithms[i], algo) {
+			return len(digestAlgorithms) - i
+		}
+	}
+
+	return 0
+}
+
+func digestHash(newHash func() hash.Hash, data string) string {
+	h := newHash()
+	io.WriteString(h, data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newCnonce() (string, error) {
+	buf := make([]byte, 16)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// requestBody - содержимое тела запроса без его расходования
+func requestBody(req *http.Request) (_ []byte, err error) {
+	var buf bytes.Buffer
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if err = copyBody(&buf, req); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenges - разбор заголовка WWW-Authenticate, в котором может быть несколько схем
+func parseChallenges(value string) (list []authChallenge) {
+	var cur *authChallenge
+
+	for s := strings.TrimSpace(value); s != ""; s = strings.TrimLeft(s, " \t,") {
+		token := s
+		if i := strings.IndexAny(s, " \t,="); i >= 0 {
+			token = s[:i]
+		}
+
+		rest := strings.TrimLeft(s[len(token):], " \t")
+
+		// Токен без знака равенства - начало новой схемы
+		if !strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, "==") {
+			list = append(list, authChallenge{scheme: token, params: make(map[string]string, 8)})
+			cur = &list[len(list)-1]
+			s = rest
+			continue
+		}
+
+		rest = strings.TrimLeft(rest[1:], " \t")
+
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			val, rest = unquote(rest)
+		} else {
+			val = rest
+			if i := strings.IndexAny(rest, " \t,"); i >= 0 {
+				val = rest[:i]
+			}
+			rest = rest[len(val):]
+		}
+
+		if cur != nil {
+			cur.params[strings.ToLower(token)] = val
+		}
+
+		s = rest
+	}
+
+	return list
+}
+
+// unquote - значение в кавычках и остаток строки после него
+func unquote(s string) (string, string) {
+	buf := new(strings.Builder)
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				buf.WriteByte(s[i])
+			}
+		case '"':
+			return buf.String(), s[i+1:]
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+
+	return buf.String(), ""
+}
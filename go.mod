@@ -1,6 +1,6 @@
 module github.com/shestakovda/webx
 
-go 1.13
+go 1.16
 
 require (
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
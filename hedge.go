@@ -0,0 +1,125 @@
+package webx
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
+
+var ErrMsgNoHedge = "Дублировать можно только GET, HEAD или явно идемпотентные запросы"
+
+// Hedge - если ответа нет дольше delay, отправляется дубль запроса, но не больше extra дублей
+func Hedge(delay time.Duration, extra int) Option {
+	return func(o *options) error {
+		if delay <= 0 || extra < 1 {
+			return ErrBadOption.WithStack()
+		}
+
+		o.hedgeDelay = delay
+		o.hedgeExtra = extra
+		return nil
+	}
+}
+
+// hedgeable - проверяется до сборки тела, чтобы отказ не оставлял за собой начатую отправку формы
+func hedgeable(ref string, opts *options) errx.Error {
+	if opts.hedgeExtra == 0 || opts.method == http.MethodGet || opts.method == http.MethodHead || opts.idempotent {
+		return nil
+	}
+
+	return ErrBadOption.WithDetail(ErrMsgNoHedge).WithDebug(errx.Debug{
+		"URL":    ref,
+		"Method": opts.method,
+	})
+}
+
+type hedgeResult struct {
+	res    Response
+	err    error
+	num    int
+	cancel context.CancelFunc
+}
+
+// hedged - побеждает первый успешный ответ, остальные попытки отменяются
+func hedged(delay time.Duration, extra int, next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (_ Response, err error) {
+		var last hedgeResult
+
+		results := make(chan hedgeResult, extra+1)
+		cancels := make([]context.CancelFunc, 0, extra+1)
+
+		launch := func(req *http.Request) {
+			ctx, cancel := context.WithCancel(req.Context())
+			num := len(cancels)
+			cancels = append(cancels, cancel)
+
+			go func() {
+				res, err := next.Do(req.WithContext(ctx))
+				results <- hedgeResult{res: res, err: err, num: num, cancel: cancel}
+			}()
+		}
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		launch(req)
+
+		for pending := 1; pending > 0; {
+			select {
+			case <-timer.C:
+				if len(cancels) > extra {
+					continue
+				}
+
+				// Дубль без тела не отправить, ждем тот, что уже в пути
+				if dup, rerr := replay(req); rerr == nil {
+					launch(dup)
+					pending++
+					timer.Reset(delay)
+				}
+			case last = <-results:
+				if pending--; last.err != nil {
+					if pending > 0 {
+						if last.res != nil {
+							last.res.Close()
+						}
+						last.cancel()
+					}
+					continue
+				}
+
+				// Проигравшие отменяются, их ответы закрываются по мере прихода
+				for i := range cancels {
+					if i != last.num {
+						cancels[i]()
+					}
+				}
+
+				go drain(results, pending)
+				return winner(last, len(cancels)-1), nil
+			}
+		}
+
+		return winner(last, len(cancels)-1), last.err
+	})
+}
+
+// winner - контекст победителя живет, пока открыт поток ответа
+func winner(win hedgeResult, hedges int) Response {
+	if r, ok := win.res.(*v1Response); ok {
+		r.hedges = hedges
+	}
+
+	releaseOnClose(win.res, win.cancel)
+	return win.res
+}
+
+func drain(results chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.res != nil {
+			r.res.Close()
+		}
+	}
+}
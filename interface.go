@@ -1,6 +1,12 @@
 package webx
 
-import "github.com/shestakovda/errx"
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
 
 const (
 	HeaderXAPIKey       = "X-API-Key"
@@ -9,6 +15,21 @@ const (
 	HeaderContentDisp   = "Content-Disposition"
 	HeaderLastModified  = "Last-Modified"
 	HeaderAuthorization = "Authorization"
+	HeaderRetryAfter    = "Retry-After"
+	HeaderAuthenticate  = "WWW-Authenticate"
+	HeaderContentLength = "Content-Length"
+	HeaderContentSHA256 = "X-Content-SHA256"
+	HeaderAge           = "Age"
+	HeaderDate          = "Date"
+	HeaderETag          = "ETag"
+	HeaderVary          = "Vary"
+	HeaderExpires       = "Expires"
+	HeaderCacheControl  = "Cache-Control"
+	HeaderIfNoneMatch   = "If-None-Match"
+	HeaderIfModSince    = "If-Modified-Since"
+	HeaderRateRemaining = "X-RateLimit-Remaining"
+	HeaderRateReset     = "X-RateLimit-Reset"
+	HeaderLink          = "Link"
 
 	MimeXML     = "text/xml; charset=utf-8"
 	MimeZIP     = "application/zip; application/octet-stream"
@@ -16,10 +37,40 @@ const (
 	MimeJSON    = "application/json; charset=utf-8"
 	MimeText    = "text/html; charset=utf-8"
 	MimeUnknown = "application/octet-stream"
+	MimeProblem = "application/problem+json"
+	MimeForm    = "application/x-www-form-urlencoded"
 )
 
+const (
+	// AppendPath - путь запроса дописывается к базовому пути, как бы он ни начинался
+	AppendPath ResolveMode = iota + 1
+
+	// ResolveRef - разрешение ссылки по RFC 3986, как url.URL.ResolveReference
+	ResolveRef
+)
+
+type ResolveMode int
+
+const (
+	// FormMultipart - составное тело multipart/form-data
+	FormMultipart FormMode = iota
+
+	// FormURLEncoded - поля в виде application/x-www-form-urlencoded, файлы недопустимы
+	FormURLEncoded
+
+	// FormAuto - urlencoded, если в форме нет файлов, иначе составное тело
+	FormAuto
+)
+
+type FormMode int
+
 func NewRequest(baseURL string, args ...Option) (Request, error) { return newRequestV1(baseURL, args) }
 
+// NewBalancedRequest - запрос к одному из нескольких равноправных адресов
+func NewBalancedRequest(baseURLs []string, strategy Strategy, args ...Option) (Request, error) {
+	return newBalancerV1(baseURLs, strategy, args)
+}
+
 type Request interface {
 	Make(string, ...Option) (Response, error)
 }
@@ -32,14 +83,50 @@ type Response interface {
 	File() (*File, error)
 	JSON(interface{}) error
 	Error() error
+
+	Header() http.Header
+	Cookies() []*http.Cookie
+	Proto() string
+	ContentLength() int64
+	FinalURL() string
+	Timing() Timing
+
+	Reader() io.ReadCloser
+	Close() error
+	WriteTo(io.Writer) (int64, error)
+	SaveAs(string) (int64, error)
+
+	Cached() bool
+	Endpoint() string
+	Hedges() int
+}
+
+// Doer - звено цепочки выполнения запроса
+type Doer interface {
+	Do(*http.Request) (Response, error)
 }
 
+type DoerFunc func(*http.Request) (Response, error)
+
+func (f DoerFunc) Do(req *http.Request) (Response, error) { return f(req) }
+
+// Middleware - перехватчик, оборачивающий следующее звено цепочки
+type Middleware func(next Doer) Doer
+
 type File struct {
 	Name string
 	Mime string
 	Data []byte
 }
 
+type Timing struct {
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration
+	Total     time.Duration
+}
+
 type Option func(*options) error
 
 var (
@@ -49,4 +136,16 @@ var (
 	ErrBadRequest  = errx.New("Некорректные данные запроса")
 	ErrBadResponse = errx.New("Некорректные данные ответа")
 	ErrResponse    = errx.New("Ошибка выполнения запроса")
+	ErrConflict    = errx.New("Конфликт состояния ресурса")
+	ErrValidation  = errx.New("Данные не прошли проверку")
+	ErrRateLimit   = errx.New("Превышен лимит запросов")
+	ErrTimeout     = errx.New("Превышено время ожидания ответа")
+	ErrServer      = errx.New("Ошибка на стороне сервера")
+	ErrRedirect    = errx.New("Перенаправление не выполнено")
+	ErrCredentials = errx.New("Ошибка получения авторизации")
+	ErrCache       = errx.New("Ошибка хранилища кеша")
+	ErrCircuitOpen = errx.New("Сервис временно отключен автоматом защиты")
+	ErrQueueFull   = errx.New("Очередь запросов переполнена")
+	ErrQueueWait   = errx.New("Не дождались свободного места в очереди запросов")
+	ErrBatch       = errx.New("Ошибка выполнения пакета запросов")
 )
@@ -0,0 +1,219 @@
+package webx
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
+
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+
+	defTokenLeeway = 30 * time.Second
+)
+
+var ErrMsgNoToken = "Сервер авторизации не вернул токен"
+
+// OAuth2Config - параметры получения токена OAuth2
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// RefreshToken - если задан, токен получается обменом, иначе по client_credentials
+	RefreshToken string
+
+	// Leeway - за сколько до истечения токен считается устаревшим
+	Leeway time.Duration
+
+	// Options - дополнительные опции запроса к серверу авторизации
+	Options []Option
+}
+
+// NewOAuth2Source - источник авторизации OAuth2, пригодный для опции AuthProvider
+func NewOAuth2Source(cfg OAuth2Config) (_ *OAuth2Source, err error) {
+	var addr *url.URL
+
+	if addr, err = url.Parse(cfg.TokenURL); err != nil || !addr.IsAbs() {
+		return nil, ErrBadURL.WithReason(err).WithDebug(errx.Debug{
+			"URL": cfg.TokenURL,
+		})
+	}
+
+	if cfg.ClientID == "" {
+		return nil, ErrBadOption.WithStack()
+	}
+
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = defTokenLeeway
+	}
+
+	src := &OAuth2Source{
+		cfg:     cfg,
+		path:    addr.Path,
+		refresh: cfg.RefreshToken,
+	}
+
+	// Адрес разбивается, чтобы путь не получил лишний слеш, а параметры не оказались перед ним
+	args := []Option{Auth(cfg.ClientID, cfg.ClientSecret)}
+
+	for name, list := range addr.Query() {
+		for i := range list {
+			args = append(args, AppendArg(name, list[i]))
+		}
+	}
+
+	addr.Path, addr.RawPath, addr.RawQuery = "", "", ""
+	args = append(args, cfg.Options...)
+
+	if src.req, err = NewRequest(addr.String(), args...); err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+// OAuth2Source - кеширующий источник токенов, одно обновление на все параллельные запросы
+type OAuth2Source struct {
+	sync.Mutex
+
+	cfg  OAuth2Config
+	req  Request
+	path string
+
+	token   string
+	kind    string
+	refresh string
+	fetched time.Time
+	expires time.Time
+}
+
+type oauth2Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s *OAuth2Source) Authorization(ctx context.Context, refresh bool) (_ string, err error) {
+	start := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Пока ждали блокировку, токен мог обновить параллельный запрос
+	if refresh && s.fetched.After(start) {
+		refresh = false
+	}
+
+	if refresh || !s.valid() {
+		if err = s.fetch(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	return s.kind + " " + s.token, nil
+}
+
+// Token - текущий токен доступа, при необходимости обновленный
+func (s *OAuth2Source) Token(ctx context.Context) (_ string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.valid() {
+		if err = s.fetch(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	return s.token, nil
+}
+
+func (s *OAuth2Source) valid() bool {
+	if s.token == "" {
+		return false
+	}
+
+	return s.expires.IsZero() || time.Now().Add(s.cfg.Leeway).Before(s.expires)
+}
+
+func (s *OAuth2Source) fetch(ctx context.Context) (err error) {
+	var res Response
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	args := []Option{
+		POST(),
+		Context(ctx),
+		ReplaceHeader("Accept", MimeJSON),
+		FormEncoding(FormURLEncoded),
+	}
+
+	if s.refresh != "" {
+		args = append(args, FieldStr("grant_type", GrantRefreshToken), FieldStr("refresh_token", s.refresh))
+	} else {
+		args = append(args, FieldStr("grant_type", GrantClientCredentials))
+	}
+
+	if len(s.cfg.Scopes) > 0 {
+		args = append(args, FieldStr("scope", strings.Join(s.cfg.Scopes, " ")))
+	}
+
+	if res, err = s.req.Make(s.path, args...); err != nil {
+		return ErrCredentials.WithReason(err)
+	}
+
+	tok := new(oauth2Token)
+
+	if err = res.JSON(tok); err != nil {
+		return ErrCredentials.WithReason(err)
+	}
+
+	if tok.AccessToken == "" {
+		return ErrCredentials.WithDetail(ErrMsgNoToken).WithDebug(errx.Debug{
+			"URL":   res.URL(),
+			"Ответ": res.Text(),
+		})
+	}
+
+	s.token = tok.AccessToken
+	s.fetched = time.Now()
+
+	if s.kind = tok.TokenType; s.kind == "" || strings.EqualFold(s.kind, AuthBearer) {
+		s.kind = AuthBearer
+	}
+
+	if tok.RefreshToken != "" {
+		s.refresh = tok.RefreshToken
+	}
+
+	if tok.ExpiresIn > 0 {
+		s.expires = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	} else {
+		s.expires = time.Time{}
+	}
+
+	return nil
+}
+
+// OAuth2 - авторизация токенами OAuth2, получаемыми по указанным параметрам
+func OAuth2(cfg OAuth2Config) Option {
+	return func(o *options) (err error) {
+		var src *OAuth2Source
+
+		if src, err = NewOAuth2Source(cfg); err != nil {
+			return ErrBadOption.WithReason(err)
+		}
+
+		o.auth = &credsAuth{creds: src}
+		return nil
+	}
+}
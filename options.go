@@ -7,11 +7,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/shestakovda/errx"
 )
@@ -26,8 +31,8 @@ func getOpts(args []Option) (o options, err error) {
 	o.setget = make(url.Values, 4)
 	o.addhead = make(http.Header, 4)
 	o.sethead = make(http.Header, 4)
-	o.form = make(map[string][]byte, 4)
-	o.file = make(map[string][]*formFile, 4)
+	o.form = make([]formPart, 0, 4)
+	o.params = make(map[string]string, 4)
 
 	for i := range args {
 		if err = args[i](&o); err != nil {
@@ -39,11 +44,10 @@ func getOpts(args []Option) (o options, err error) {
 }
 
 type options struct {
-	user string
-	pass string
-	body io.Reader
-	form map[string][]byte
-	file map[string][]*formFile
+	auth   authorizer
+	body   io.Reader
+	form   []formPart
+	params map[string]string
 
 	debug   bool
 	method  string
@@ -53,63 +57,306 @@ type options struct {
 	sethead http.Header
 	client  *http.Client
 
-	ctx context.Context
+	retries  int
+	delay    time.Duration
+	maxDelay time.Duration
+	retryIf  func(int, error) bool
+
+	stream  bool
+	maxBody int64
+
+	accept   []int
+	classify func(int) error
+	errBody  interface{}
+
+	use    []Middleware
+	signer Signer
+	cache  CacheStore
+
+	limiter   *rateLimiter
+	rateAdapt bool
+	breaker   *breaker
+	bulkhead  *bulkhead
+	dedup     *flightGroup
+
+	formMode   FormMode
+	boundary   string
+	idempotent bool
+	absolute   bool
+	resolve    ResolveMode
+	hedgeDelay time.Duration
+	hedgeExtra int
+	ejectAfter int
+	ejectPause time.Duration
+
+	ctx    context.Context
+	length int64
 }
 
-func (o *options) Body() (_ io.Reader, err error) {
+// shared - заданы ли параметры с общим для всех вызовов состоянием
+func (o *options) shared() bool {
+	return o.breaker != nil || o.limiter != nil || o.bulkhead != nil || o.dedup != nil || o.ejectAfter != 0
+}
+
+func (o *options) Body(replay bool) (_ bodyFunc, err error) {
 	if o.method == http.MethodGet || o.method == http.MethodHead {
 		return nil, nil
 	}
 
-	if o.body != nil {
-		return o.body, nil
-	}
+	if o.body == nil {
+		var urlencoded bool
 
-	if err = o.makeForm(); err != nil {
-		return
+		if urlencoded, err = o.urlencoded(); err != nil {
+			return nil, err
+		}
+
+		if urlencoded {
+			return o.encodeForm(replay)
+		}
+
+		return o.makeForm()
 	}
 
-	return o.body, nil
+	return newBodyFunc(o.body, replay)
 }
 
-func (o *options) makeForm() (err error) {
-	var flw io.Writer
+// urlencoded - форма без файлов может быть отправлена простым списком полей
+func (o *options) urlencoded() (bool, error) {
+	switch o.formMode {
+	case FormURLEncoded:
+		if o.hasFiles() {
+			return false, ErrBadBody.WithDetail(ErrMsgFormFiles)
+		}
+		return true, nil
+	case FormAuto:
+		return !o.hasFiles(), nil
+	}
 
-	buf := new(bytes.Buffer)
-	form := multipart.NewWriter(buf)
+	return false, nil
+}
 
-	for field, data := range o.form {
-		if flw, err = form.CreateFormField(field); err != nil {
-			return ErrBadBody.WithReason(err)
+func (o *options) hasFiles() bool {
+	for i := range o.form {
+		if o.form[i].File != nil {
+			return true
 		}
+	}
 
-		if _, err = flw.Write(data); err != nil {
-			return ErrBadBody.WithReason(err)
+	return false
+}
+
+// encodeForm - поля в порядке их добавления, одно имя может повторяться
+func (o *options) encodeForm(replay bool) (bodyFunc, error) {
+	buf := new(strings.Builder)
+
+	for i := range o.form {
+		if i > 0 {
+			buf.WriteByte('&')
 		}
+
+		buf.WriteString(url.QueryEscape(o.form[i].Name))
+		buf.WriteByte('=')
+		buf.WriteString(url.QueryEscape(string(o.form[i].Data)))
 	}
 
-	for field := range o.file {
-		for i := range o.file[field] {
-			if flw, err = form.CreatePart(o.file[field][i].Header); err != nil {
-				return ErrBadBody.WithReason(err)
-			}
+	o.sethead.Set(HeaderContentType, MimeForm)
+	return newBodyFunc(strings.NewReader(buf.String()), replay)
+}
 
-			if _, err = flw.Write(o.file[field][i].Buffer); err != nil {
-				return ErrBadBody.WithReason(err)
-			}
+// makeForm - составное тело пишется в трубу по мере чтения, без сборки в памяти
+func (o *options) makeForm() (_ bodyFunc, err error) {
+	form := multipart.NewWriter(ioutil.Discard)
+
+	if o.boundary != "" {
+		if err = form.SetBoundary(o.boundary); err != nil {
+			return nil, ErrBadBody.WithReason(err)
 		}
 	}
 
+	boundary := form.Boundary()
+	parts := o.form
+
+	if o.length, err = formLength(boundary, parts); err != nil {
+		return nil, err
+	}
+
 	o.sethead.Set(HeaderContentType, form.FormDataContentType())
 
+	var used int32
+	return func() (io.Reader, error) {
+		if atomic.SwapInt32(&used, 1) == 1 && !formReplayable(parts) {
+			return nil, ErrBadBody.WithDetail(ErrMsgNoReplay)
+		}
+
+		pr, pw := io.Pipe()
+
+		go func() {
+			pw.CloseWithError(writeForm(pw, boundary, parts))
+		}()
+
+		return pr, nil
+	}, nil
+}
+
+// writeForm - части идут строго в порядке добавления
+func writeForm(w io.Writer, boundary string, parts []formPart) (err error) {
+	var flw io.Writer
+
+	form := multipart.NewWriter(w)
+
+	if err = form.SetBoundary(boundary); err != nil {
+		return ErrBadBody.WithReason(err)
+	}
+
+	for i := range parts {
+		if flw, err = form.CreatePart(parts[i].MIMEHeader()); err != nil {
+			return ErrBadBody.WithReason(err)
+		}
+
+		if parts[i].File != nil {
+			err = parts[i].File.Copy(flw)
+		} else if _, err = flw.Write(parts[i].Data); err != nil {
+			err = ErrBadBody.WithReason(err)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
 	if err = form.Close(); err != nil {
 		return ErrBadBody.WithReason(err)
 	}
 
-	o.body = bytes.NewReader(buf.Bytes())
 	return nil
 }
 
+// formLength - размер составного тела, если размеры всех файлов известны заранее, иначе -1
+func formLength(boundary string, parts []formPart) (n int64, err error) {
+	var size int64
+
+	cnt := new(counter)
+	form := multipart.NewWriter(cnt)
+
+	if err = form.SetBoundary(boundary); err != nil {
+		return 0, ErrBadBody.WithReason(err)
+	}
+
+	for i := range parts {
+		if _, err = form.CreatePart(parts[i].MIMEHeader()); err != nil {
+			return 0, ErrBadBody.WithReason(err)
+		}
+
+		if parts[i].File == nil {
+			n += int64(len(parts[i].Data))
+			continue
+		}
+
+		if size, err = parts[i].File.Len(); err != nil {
+			return 0, err
+		}
+
+		if size < 0 {
+			return -1, nil
+		}
+
+		n += size
+	}
+
+	if err = form.Close(); err != nil {
+		return 0, ErrBadBody.WithReason(err)
+	}
+
+	return n + cnt.n, nil
+}
+
+func formReplayable(parts []formPart) bool {
+	for i := range parts {
+		if parts[i].File != nil && parts[i].File.Source.once {
+			return false
+		}
+	}
+	return true
+}
+
+type counter struct{ n int64 }
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// bodyFunc - источник тела запроса, каждый вызов которого отдает тело с самого начала
+type bodyFunc func() (io.Reader, error)
+
+func (f bodyFunc) ReadCloser() (_ io.ReadCloser, err error) {
+	var body io.Reader
+
+	if body, err = f(); err != nil {
+		return
+	}
+
+	if rc, ok := body.(io.ReadCloser); ok {
+		return rc, nil
+	}
+
+	return ioutil.NopCloser(body), nil
+}
+
+type readSeekerAt interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+func newBodyFunc(body io.Reader, replay bool) (_ bodyFunc, err error) {
+	var pos, end int64
+	var buf []byte
+
+	switch src := body.(type) {
+	case nil:
+		return nil, nil
+	case *bytes.Buffer:
+		// Содержимое уже в памяти, можно читать сколько угодно раз
+		buf = src.Bytes()
+	case *bytes.Reader, *strings.Reader:
+		if buf, err = ioutil.ReadAll(src); err != nil {
+			return nil, ErrBadBody.WithReason(err)
+		}
+	case readSeekerAt:
+		// Каждая отправка читает свой участок с текущей позиции, не трогая и не закрывая источник
+		if pos, err = src.Seek(0, io.SeekCurrent); err != nil {
+			return nil, ErrBadBody.WithReason(err)
+		}
+
+		if end, err = src.Seek(0, io.SeekEnd); err != nil {
+			return nil, ErrBadBody.WithReason(err)
+		}
+
+		if _, err = src.Seek(pos, io.SeekStart); err != nil {
+			return nil, ErrBadBody.WithReason(err)
+		}
+
+		return func() (io.Reader, error) { return io.NewSectionReader(src, pos, end-pos), nil }, nil
+	default:
+		if !replay {
+			// Повторов не будет - нет смысла держать всё тело в памяти
+			var used int32
+			return func() (io.Reader, error) {
+				if atomic.SwapInt32(&used, 1) == 1 {
+					return nil, ErrBadBody.WithDetail(ErrMsgNoReplay)
+				}
+				return body, nil
+			}, nil
+		}
+
+		if buf, err = ioutil.ReadAll(src); err != nil {
+			return nil, ErrBadBody.WithReason(err)
+		}
+	}
+
+	return func() (io.Reader, error) { return bytes.NewReader(buf), nil }, nil
+}
+
 func AppendArg(name, value string) Option {
 	return func(o *options) error {
 		if name == "" {
@@ -138,8 +385,7 @@ func Auth(user, pass string) Option {
 			return ErrBadOption.WithStack()
 		}
 
-		o.user = user
-		o.pass = pass
+		o.auth = &basicAuth{user: user, pass: pass}
 		return nil
 	}
 }
@@ -162,7 +408,14 @@ func Files(files map[string][]*File) Option {
 			return ErrBadOption.WithStack()
 		}
 
+		// Порядок полей не должен зависеть от порядка обхода словаря
+		names := make([]string, 0, len(files))
 		for field := range files {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+
+		for _, field := range names {
 			for i := range files[field] {
 				if files[field][i] == nil || files[field][i].Name == "" {
 					return ErrBadOption.WithStack().WithDebug(errx.Debug{
@@ -170,7 +423,7 @@ func Files(files map[string][]*File) Option {
 					})
 				}
 
-				o.file[field] = append(o.file[field], newFormFile(field, files[field][i], false))
+				o.form = append(o.form, formPart{Name: field, File: newFormFile(field, uploadFile(files[field][i]), false)})
 			}
 		}
 
@@ -184,7 +437,7 @@ func Field(name string, data []byte) Option {
 			return ErrBadOption.WithStack()
 		}
 
-		o.form[name] = data
+		o.form = append(o.form, formPart{Name: name, Data: data})
 		return nil
 	}
 }
@@ -195,14 +448,64 @@ func FieldStr(name string, data string) Option {
 
 func FieldJSON(name string, data interface{}) Option {
 	return func(o *options) (err error) {
+		var buf []byte
+
+		if name == "" {
+			return ErrBadOption.WithStack()
+		}
+
+		if buf, err = json.Marshal(data); err != nil {
+			return ErrBadOption.WithReason(err)
+		}
+
+		o.form = append(o.form, formPart{Name: name, Data: buf})
+		return nil
+	}
+}
+
+// FieldPart - поле формы с собственными заголовками части, например Content-Type с кодировкой
+func FieldPart(name string, data []byte, head http.Header) Option {
+	return func(o *options) error {
 		if name == "" {
 			return ErrBadOption.WithStack()
 		}
 
-		if o.form[name], err = json.Marshal(data); err != nil {
+		o.form = append(o.form, formPart{Name: name, Data: data, Header: head.Clone()})
+		return nil
+	}
+}
+
+// Boundary - постоянный разделитель частей формы вместо случайного
+func Boundary(boundary string) Option {
+	return func(o *options) error {
+		if err := multipart.NewWriter(ioutil.Discard).SetBoundary(boundary); err != nil {
 			return ErrBadOption.WithReason(err)
 		}
 
+		o.boundary = boundary
+		return nil
+	}
+}
+
+// Form - поля формы из полей структуры, теги те же, что и у Query
+func Form(v interface{}) Option {
+	return func(o *options) error {
+		return encodeStruct(v, func(name string, list []string) {
+			for i := range list {
+				o.form = append(o.form, formPart{Name: name, Data: []byte(list[i])})
+			}
+		})
+	}
+}
+
+// FormEncoding - как кодировать поля формы, по умолчанию FormMultipart
+func FormEncoding(mode FormMode) Option {
+	return func(o *options) error {
+		if mode < FormMultipart || mode > FormAuto {
+			return ErrBadOption.WithStack()
+		}
+
+		o.formMode = mode
 		return nil
 	}
 }
@@ -220,7 +523,7 @@ func FieldFile(field string, files ...*File) Option {
 				})
 			}
 
-			o.file[field] = append(o.file[field], newFormFile(field, files[i], false))
+			o.form = append(o.form, formPart{Name: field, File: newFormFile(field, uploadFile(files[i]), false)})
 		}
 
 		return nil
@@ -240,7 +543,46 @@ func FieldFileAsBase64(field string, files ...*File) Option {
 				})
 			}
 
-			o.file[field] = append(o.file[field], newFormFile(field, files[i], true))
+			o.form = append(o.form, formPart{Name: field, File: newFormFile(field, uploadFile(files[i]), true)})
+		}
+		return nil
+	}
+}
+
+func FieldUpload(field string, files ...*Upload) Option {
+	return func(o *options) error {
+		if field == "" || len(files) == 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		for i := range files {
+			if files[i] == nil || files[i].Name == "" {
+				return ErrBadOption.WithStack().WithDebug(errx.Debug{
+					"index": i,
+				})
+			}
+
+			o.form = append(o.form, formPart{Name: field, File: newFormFile(field, files[i], false)})
+		}
+
+		return nil
+	}
+}
+
+func FieldUploadAsBase64(field string, files ...*Upload) Option {
+	return func(o *options) error {
+		if field == "" || len(files) == 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		for i := range files {
+			if files[i] == nil || files[i].Name == "" {
+				return ErrBadOption.WithStack().WithDebug(errx.Debug{
+					"index": i,
+				})
+			}
+
+			o.form = append(o.form, formPart{Name: field, File: newFormFile(field, files[i], true)})
 		}
 		return nil
 	}
@@ -311,6 +653,128 @@ func POST() Option   { return Method(http.MethodPost) }
 func PATCH() Option  { return Method(http.MethodPatch) }
 func DELETE() Option { return Method(http.MethodDelete) }
 
+func Retry(attempts int, delay, limit time.Duration) Option {
+	return func(o *options) error {
+		if attempts < 1 || delay < 0 || limit < delay {
+			return ErrBadOption.WithStack()
+		}
+
+		o.retries = attempts
+		o.delay = delay
+		o.maxDelay = limit
+		return nil
+	}
+}
+
+func RetryIf(check func(code int, err error) bool) Option {
+	return func(o *options) error {
+		if check == nil {
+			return ErrBadOption.WithStack()
+		}
+
+		o.retryIf = check
+		return nil
+	}
+}
+
+func Stream() Option {
+	return func(o *options) error {
+		o.stream = true
+		return nil
+	}
+}
+
+func MaxBodySize(size int64) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		o.maxBody = size
+		return nil
+	}
+}
+
+func Classifier(classify func(code int) error) Option {
+	return func(o *options) error {
+		if classify == nil {
+			return ErrBadOption.WithStack()
+		}
+
+		o.classify = classify
+		return nil
+	}
+}
+
+func AcceptCodes(codes ...int) Option {
+	return func(o *options) error {
+		if len(codes) == 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		for i := range codes {
+			if codes[i] < 100 || codes[i] > 999 {
+				return ErrBadOption.WithStack().WithDebug(errx.Debug{
+					"index": i,
+				})
+			}
+		}
+
+		o.accept = append(o.accept, codes...)
+		return nil
+	}
+}
+
+func ErrorBody(target interface{}) Option {
+	return func(o *options) error {
+		if val := reflect.ValueOf(target); val.Kind() != reflect.Ptr || val.IsNil() {
+			return ErrBadOption.WithStack()
+		}
+
+		o.errBody = target
+		return nil
+	}
+}
+
+func Use(mw ...Middleware) Option {
+	return func(o *options) error {
+		if len(mw) == 0 {
+			return ErrBadOption.WithStack()
+		}
+
+		for i := range mw {
+			if mw[i] == nil {
+				return ErrBadOption.WithStack().WithDebug(errx.Debug{
+					"index": i,
+				})
+			}
+		}
+
+		o.use = append(o.use, mw...)
+		return nil
+	}
+}
+
+// AllowAbsolute - разрешить запросы по абсолютному адресу вместо базового
+func AllowAbsolute() Option {
+	return func(o *options) error {
+		o.absolute = true
+		return nil
+	}
+}
+
+// Resolve - как путь запроса соединяется с базовым путем
+func Resolve(mode ResolveMode) Option {
+	return func(o *options) error {
+		if mode != AppendPath && mode != ResolveRef {
+			return ErrBadOption.WithStack()
+		}
+
+		o.resolve = mode
+		return nil
+	}
+}
+
 func Debug() Option {
 	return func(o *options) error {
 		o.debug = true
@@ -325,10 +789,12 @@ func Context(ctx context.Context) Option {
 	}
 }
 
-func newFormFile(field string, file *File, as64 bool) *formFile {
-	const tpl = `form-data; name="%s"; filename="%s" filename*=utf-8''%s`
+func newFormFile(field string, file *Upload, as64 bool) *formFile {
+	const tpl = `form-data; name="%s"; filename="%s"; filename*=utf-8''%s`
 
 	f := &formFile{
+		Base64: as64,
+		Source: file,
 		Header: make(textproto.MIMEHeader),
 	}
 
@@ -341,17 +807,79 @@ func newFormFile(field string, file *File, as64 bool) *formFile {
 	}
 
 	if as64 {
-		f.Buffer = make([]byte, base64.StdEncoding.EncodedLen(len(file.Data)))
-		base64.StdEncoding.Encode(f.Buffer, file.Data)
 		f.Header.Set(HeaderContentEnc, "base64")
-	} else {
-		f.Buffer = file.Data
 	}
 
 	return f
 }
 
+// formPart - поле или файл формы
+type formPart struct {
+	Name   string
+	Data   []byte
+	Header http.Header
+	File   *formFile
+}
+
+// MIMEHeader - заголовки части, имя поля всегда задается самой формой
+func (p *formPart) MIMEHeader() textproto.MIMEHeader {
+	if p.File != nil {
+		return p.File.Header
+	}
+
+	head := make(textproto.MIMEHeader, len(p.Header)+1)
+
+	for name, list := range p.Header {
+		head[textproto.CanonicalMIMEHeaderKey(name)] = list
+	}
+
+	head.Set(HeaderContentDisp, fmt.Sprintf(`form-data; name="%s"`, escQuotes(p.Name)))
+	return head
+}
+
 type formFile struct {
-	Buffer []byte
+	Base64 bool
+	Source *Upload
 	Header textproto.MIMEHeader
 }
+
+// Len - размер части после кодирования, -1 если неизвестен
+func (f *formFile) Len() (size int64, err error) {
+	if size, err = f.Source.size(); err != nil || size < 0 {
+		return
+	}
+
+	if f.Base64 {
+		size = int64(base64.StdEncoding.EncodedLen(int(size)))
+	}
+
+	return size, nil
+}
+
+func (f *formFile) Copy(w io.Writer) (err error) {
+	var src io.ReadCloser
+
+	if src, err = f.Source.open(); err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if !f.Base64 {
+		if _, err = io.Copy(w, src); err != nil {
+			return ErrBadBody.WithReason(err)
+		}
+		return nil
+	}
+
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+
+	if _, err = io.Copy(enc, src); err != nil {
+		return ErrBadBody.WithReason(err)
+	}
+
+	if err = enc.Close(); err != nil {
+		return ErrBadBody.WithReason(err)
+	}
+
+	return nil
+}
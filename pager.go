@@ -0,0 +1,443 @@
+package webx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/shestakovda/errx"
+)
+
+const (
+	PageLink PageMode = iota
+	PageCursor
+	PageOffset
+	PageNumber
+)
+
+var ErrMsgNoItems = "Не найден список элементов страницы"
+var ErrMsgForeignLink = "Ссылка на следующую страницу ведет за пределы базового адреса"
+
+// PageMode - как найти следующую страницу
+type PageMode int
+
+// PageConfig - параметры обхода страниц
+type PageConfig struct {
+	Mode PageMode
+
+	// Items - путь к списку элементов в JSON через точку, пустой - весь ответ это список
+	Items string
+
+	// Cursor - путь к курсору следующей страницы в JSON через точку, CursorArg - аргумент для него
+	Cursor    string
+	CursorArg string
+
+	// Offset и Limit - аргументы смещения и размера, Page - аргумент номера страницы
+	Offset string
+	Limit  string
+	Page   string
+
+	// Size - размер страницы, First - номер первой страницы, по умолчанию 1
+	Size  int
+	First int
+
+	// MaxPages - не больше стольких страниц, 0 без ограничения
+	MaxPages int
+
+	// Stop - прекратить обход после этой страницы
+	Stop func(page Response, items int) bool
+}
+
+// Paginate - обход страниц списка по одному элементу
+func Paginate(ctx context.Context, req Request, ref string, cfg PageConfig, args ...Option) *Pager {
+	p := &Pager{
+		ctx:  ctx,
+		req:  req,
+		ref:  ref,
+		cfg:  cfg,
+		args: args,
+		more: true,
+	}
+
+	if p.cfg.First == 0 {
+		p.cfg.First = 1
+	}
+
+	if p.err = p.check(); p.err != nil {
+		return p
+	}
+
+	switch cfg.Mode {
+	case PageOffset:
+		p.next = p.offset(0)
+	case PageNumber:
+		p.next = p.number(p.cfg.First)
+	}
+
+	return p
+}
+
+// Pager - итератор в духе bufio.Scanner: Next, затем Scan или Item, в конце Err
+type Pager struct {
+	ctx   context.Context
+	req   Request
+	ref   string
+	cfg   PageConfig
+	args  []Option
+	next  []Option
+	more  bool
+	err   error
+	page  Response
+	list  []json.RawMessage
+	pos   int
+	pages int
+	seen  int
+	num   int
+}
+
+func (p *Pager) Next() bool {
+	for p.err == nil {
+		if p.pos < len(p.list) {
+			p.pos++
+			return true
+		}
+
+		if !p.more || (p.cfg.MaxPages > 0 && p.pages >= p.cfg.MaxPages) {
+			return false
+		}
+
+		p.err = p.load()
+	}
+
+	return false
+}
+
+// Item - текущий элемент как есть
+func (p *Pager) Item() json.RawMessage {
+	if p.pos == 0 || p.pos > len(p.list) {
+		return nil
+	}
+
+	return p.list[p.pos-1]
+}
+
+// Scan - разбор текущего элемента
+func (p *Pager) Scan(item interface{}) error {
+	if err := json.Unmarshal(p.Item(), item); err != nil {
+		return ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
+			"Элемент": string(p.Item()),
+		})
+	}
+
+	return nil
+}
+
+// Page - ответ с текущей страницей
+func (p *Pager) Page() Response { return p.page }
+
+// Pages - сколько страниц загружено
+func (p *Pager) Pages() int { return p.pages }
+
+func (p *Pager) Err() error { return p.err }
+
+func (p *Pager) check() error {
+	if p.ctx == nil || p.req == nil {
+		return ErrBadOption.WithStack()
+	}
+
+	switch p.cfg.Mode {
+	case PageLink:
+		return nil
+	case PageCursor:
+		if p.cfg.Cursor != "" && p.cfg.CursorArg != "" {
+			return nil
+		}
+	case PageOffset:
+		if p.cfg.Offset != "" && p.cfg.Limit != "" && p.cfg.Size > 0 {
+			return nil
+		}
+	case PageNumber:
+		if p.cfg.Page != "" && (p.cfg.Limit == "" || p.cfg.Size > 0) {
+			return nil
+		}
+	}
+
+	return ErrBadOption.WithStack()
+}
+
+// load - загрузка страницы и подготовка перехода к следующей
+func (p *Pager) load() (err error) {
+	if err = p.ctx.Err(); err != nil {
+		return ErrBadRequest.WithReason(err)
+	}
+
+	args := append(p.args[:len(p.args):len(p.args)], p.next...)
+	args = append(args, Context(p.ctx))
+
+	if p.page, err = p.req.Make(p.ref, args...); err != nil {
+		return err
+	}
+
+	p.pos = 0
+	p.pages++
+
+	if p.list, err = p.items(); err != nil {
+		return err
+	}
+
+	p.seen += len(p.list)
+
+	if len(p.list) == 0 || (p.cfg.Stop != nil && p.cfg.Stop(p.page, len(p.list))) {
+		p.more = false
+		return nil
+	}
+
+	return p.follow()
+}
+
+func (p *Pager) items() (list []json.RawMessage, err error) {
+	data, ok, err := jsonField(p.page.Body(), p.cfg.Items)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrBadResponse.WithDetail(ErrMsgNoItems).WithDebug(errx.Debug{
+			"URL":  p.page.URL(),
+			"Путь": p.cfg.Items,
+		})
+	}
+
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
+			"URL":  p.page.URL(),
+			"Путь": p.cfg.Items,
+		})
+	}
+
+	return list, nil
+}
+
+// follow - аргументы следующей страницы, если она есть
+func (p *Pager) follow() (err error) {
+	switch p.cfg.Mode {
+	case PageCursor:
+		var cursor string
+
+		if cursor, err = p.cursor(); err != nil || cursor == "" {
+			p.more = false
+			return err
+		}
+
+		p.next = []Option{ReplaceArg(p.cfg.CursorArg, cursor)}
+	case PageOffset:
+		if len(p.list) < p.cfg.Size {
+			p.more = false
+			return nil
+		}
+
+		p.next = p.offset(p.seen)
+	case PageNumber:
+		if p.cfg.Size > 0 && len(p.list) < p.cfg.Size {
+			p.more = false
+			return nil
+		}
+
+		p.next = p.number(p.num + 1)
+	default:
+		var link *url.URL
+
+		if link, err = p.link(); err != nil || link == nil {
+			p.more = false
+			return err
+		}
+
+		// Аргументы из ссылки важнее исходных, а параметры пути в готовой ссылке уже подставлены
+		args := link.Query()
+		link.RawQuery = ""
+		p.ref = link.String()
+		p.next = []Option{replaceArgs(args), dropParams(), AllowAbsolute()}
+	}
+
+	return nil
+}
+
+// replaceArgs - замена аргументов со всеми их значениями
+func replaceArgs(args url.Values) Option {
+	return func(o *options) error {
+		for name, list := range args {
+			o.setget[name] = list
+		}
+		return nil
+	}
+}
+
+// dropParams - сброс параметров пути, заданных для первой страницы
+func dropParams() Option {
+	return func(o *options) error {
+		o.params = make(map[string]string)
+		return nil
+	}
+}
+
+func (p *Pager) offset(n int) []Option {
+	return []Option{ReplaceArg(p.cfg.Offset, strconv.Itoa(n)), ReplaceArg(p.cfg.Limit, strconv.Itoa(p.cfg.Size))}
+}
+
+func (p *Pager) number(n int) []Option {
+	p.num = n
+	opts := []Option{ReplaceArg(p.cfg.Page, strconv.Itoa(n))}
+
+	if p.cfg.Limit != "" {
+		opts = append(opts, ReplaceArg(p.cfg.Limit, strconv.Itoa(p.cfg.Size)))
+	}
+
+	return opts
+}
+
+// cursor - курсор в виде строки или числа, пустой или null - страниц больше нет
+func (p *Pager) cursor() (string, error) {
+	data, ok, err := jsonField(p.page.Body(), p.cfg.Cursor)
+
+	if err != nil || !ok || string(data) == "null" {
+		return "", err
+	}
+
+	var cursor string
+
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		if err = json.Unmarshal(data, &cursor); err != nil {
+			return "", ErrBadResponse.WithReason(err)
+		}
+
+		return cursor, nil
+	}
+
+	return string(data), nil
+}
+
+// link - абсолютная ссылка rel="next", только в пределах базового адреса, который обслужил страницу
+func (p *Pager) link() (*url.URL, error) {
+	next := ""
+
+	for _, value := range p.page.Header()[HeaderLink] {
+		if next = parseLinks(value)["next"]; next != "" {
+			break
+		}
+	}
+
+	if next == "" {
+		return nil, nil
+	}
+
+	cur, err := url.Parse(p.page.FinalURL())
+	if err != nil {
+		return nil, ErrBadURL.WithReason(err)
+	}
+
+	ref, err := cur.Parse(next)
+	if err != nil {
+		return nil, ErrBadURL.WithReason(err).WithDebug(errx.Debug{
+			"Ссылка": next,
+		})
+	}
+
+	base := p.page.Endpoint()
+	if i := strings.IndexByte(base, '?'); i >= 0 {
+		base = base[:i]
+	}
+
+	base = strings.TrimRight(base, "/")
+	if !strings.HasPrefix(ref.String(), base+"/") {
+		return nil, ErrBadURL.WithDetail(ErrMsgForeignLink).WithDebug(errx.Debug{
+			"Ссылка": ref.String(),
+			"База":   base,
+		})
+	}
+
+	return ref, nil
+}
+
+// parseLinks - ссылки заголовка Link по их отношениям rel
+func parseLinks(value string) map[string]string {
+	links := make(map[string]string, 4)
+
+	for s := strings.TrimSpace(value); strings.HasPrefix(s, "<"); s = strings.TrimLeft(s, " \t,") {
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			break
+		}
+
+		link := s[1:end]
+		rels := ""
+		s = s[end+1:]
+
+		// Параметры ссылки до следующей запятой вне кавычек
+		for s = strings.TrimLeft(s, " \t"); strings.HasPrefix(s, ";"); s = strings.TrimLeft(s, " \t") {
+			s = strings.TrimLeft(s[1:], " \t")
+
+			name := s
+			if i := strings.IndexAny(s, "=;,"); i >= 0 {
+				name = s[:i]
+			}
+
+			s = s[len(name):]
+			name = strings.ToLower(strings.TrimSpace(name))
+
+			if !strings.HasPrefix(s, "=") {
+				continue
+			}
+
+			var val string
+			if s = strings.TrimLeft(s[1:], " \t"); strings.HasPrefix(s, `"`) {
+				val, s = unquote(s)
+			} else {
+				val = s
+				if i := strings.IndexAny(s, ";,"); i >= 0 {
+					val = s[:i]
+				}
+				s = s[len(val):]
+			}
+
+			if name == "rel" {
+				rels = val
+			}
+		}
+
+		for _, rel := range strings.Fields(strings.ToLower(rels)) {
+			if _, ok := links[rel]; !ok {
+				links[rel] = link
+			}
+		}
+	}
+
+	return links
+}
+
+// jsonField - значение по пути через точку, пустой путь - весь документ
+func jsonField(data []byte, path string) (json.RawMessage, bool, error) {
+	if path == "" {
+		return data, true, nil
+	}
+
+	for _, name := range strings.Split(path, ".") {
+		var obj map[string]json.RawMessage
+
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, false, ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
+				"Путь": path,
+			})
+		}
+
+		var ok bool
+		if data, ok = obj[name]; !ok {
+			return nil, false, nil
+		}
+	}
+
+	return data, true, nil
+}
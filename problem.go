@@ -0,0 +1,86 @@
+package webx
+
+import (
+	"encoding/json"
+
+	"github.com/shestakovda/errx"
+)
+
+// Problem - описание ошибки в формате RFC 7807
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+
+	// Extensions - все остальные поля описания, как они пришли
+	Extensions map[string]json.RawMessage
+}
+
+func (p *Problem) UnmarshalJSON(data []byte) (err error) {
+	var raw map[string]json.RawMessage
+
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return
+	}
+
+	known := map[string]interface{}{
+		"type":     &p.Type,
+		"title":    &p.Title,
+		"status":   &p.Status,
+		"detail":   &p.Detail,
+		"instance": &p.Instance,
+	}
+
+	for name, value := range raw {
+		if field, ok := known[name]; ok {
+			// Поле неверного типа не делает бесполезным всё описание
+			if json.Unmarshal(value, field) == nil {
+				continue
+			}
+		}
+
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]json.RawMessage, len(raw))
+		}
+
+		p.Extensions[name] = value
+	}
+
+	return nil
+}
+
+// ResponseError - ошибка ответа вместе с разобранным телом, достается через errx.As
+type ResponseError struct {
+	Code    int
+	Body    interface{}
+	Problem *Problem
+
+	reason error
+}
+
+func (e *ResponseError) Error() string { return e.reason.Error() }
+func (e *ResponseError) Unwrap() error { return e.reason }
+
+// ErrorBodyOf - тело ответа, разобранное по опции ErrorBody, или nil
+func ErrorBodyOf(err error) interface{} {
+	var re *ResponseError
+
+	if errx.As(err, &re) {
+		return re.Body
+	}
+
+	return nil
+}
+
+// ProblemOf - описание проблемы из ответа application/problem+json, или nil
+func ProblemOf(err error) *Problem {
+	var re *ResponseError
+
+	if errx.As(err, &re) {
+		return re.Problem
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package webx
+
+import (
+	"encoding"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shestakovda/errx"
+)
+
+var typeTime = reflect.TypeOf(time.Time{})
+var typeTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// Query - аргументы запроса из полей структуры с тегами url:"name,omitempty,comma"
+// Срезы повторяют аргумент или, с comma, склеиваются через запятую
+// Время форматируется по тегу layout:"2006-01-02", с опцией unix - секундами, по умолчанию RFC 3339
+func Query(v interface{}) Option {
+	return func(o *options) error {
+		args := make(url.Values, 8)
+
+		if err := encodeStruct(v, func(name string, list []string) { args[name] = append(args[name], list...) }); err != nil {
+			return err
+		}
+
+		for name, list := range args {
+			o.setget[name] = list
+		}
+
+		return nil
+	}
+}
+
+// encodeStruct - значения полей структуры по порядку их объявления
+func encodeStruct(v interface{}, add func(name string, list []string)) error {
+	val := reflect.ValueOf(v)
+
+	for val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct {
+		return ErrBadOption.WithStack()
+	}
+
+	return encodeFields(val, add)
+}
+
+func encodeFields(val reflect.Value, add func(name string, list []string)) error {
+	typ := val.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("url")
+
+		if tag == "-" {
+			continue
+		}
+
+		name, flags := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, flags = tag[:i], tag[i:]+","
+		}
+
+		fv := val.Field(i)
+
+		// Вложенная структура без имени раскрывается на своем уровне
+		if field.Anonymous && name == "" {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+
+			if fv.Kind() == reflect.Ptr {
+				continue
+			}
+
+			if fv.Kind() == reflect.Struct && fv.Type() != typeTime && !reflect.PtrTo(fv.Type()).Implements(typeTextMarshaler) {
+				if err := encodeFields(fv, add); err != nil {
+					return err
+				}
+				continue
+			}
+
+			fv = val.Field(i)
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if strings.Contains(flags, ",omitempty,") && isEmptyValue(fv) {
+			continue
+		}
+
+		list, err := queryValues(fv, field.Tag.Get("layout"), strings.Contains(flags, ",unix,"))
+		if err != nil {
+			return err.WithDebug(errx.Debug{
+				"Поле": field.Name,
+			})
+		}
+
+		if strings.Contains(flags, ",comma,") && len(list) > 0 {
+			list = []string{strings.Join(list, ",")}
+		}
+
+		add(name, list)
+	}
+
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		return v.Len() == 0
+	}
+
+	return v.IsZero()
+}
+
+func queryValues(v reflect.Value, layout string, unix bool) (_ []string, err errx.Error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return []string{""}, nil
+		}
+		v = v.Elem()
+	}
+
+	// Байты - это одно значение, а не список
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		return []string{string(v.Bytes())}, nil
+	}
+
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		list := make([]string, 0, v.Len())
+
+		for i := 0; i < v.Len(); i++ {
+			item, err := queryValue(v.Index(i), layout, unix)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+
+		return list, nil
+	}
+
+	item, err := queryValue(v, layout, unix)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{item}, nil
+}
+
+func queryValue(v reflect.Value, layout string, unix bool) (string, errx.Error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == typeTime {
+		t := v.Interface().(time.Time)
+
+		switch {
+		case unix:
+			return strconv.FormatInt(t.Unix(), 10), nil
+		case layout != "":
+			return t.Format(layout), nil
+		}
+
+		return t.Format(time.RFC3339), nil
+	}
+
+	if m, ok := textMarshaler(v); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return "", ErrBadOption.WithReason(err)
+		}
+		return string(text), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+
+	return "", ErrBadOption.WithDebug(errx.Debug{
+		"Тип": v.Type().String(),
+	})
+}
+
+// textMarshaler - значение или указатель на него умеют сами себя представить текстом
+func textMarshaler(v reflect.Value) (encoding.TextMarshaler, bool) {
+	if v.Type().Implements(typeTextMarshaler) {
+		return v.Interface().(encoding.TextMarshaler), true
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(typeTextMarshaler) {
+		return v.Addr().Interface().(encoding.TextMarshaler), true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,134 @@
+package webx
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit - ограничение частоты отправки, задается только для базового запроса
+func RateLimit(rps float64, burst int) Option {
+	return func(o *options) error {
+		if rps <= 0 || burst < 1 {
+			return ErrBadOption.WithStack()
+		}
+
+		o.limiter = newRateLimiter(rps, burst)
+		return nil
+	}
+}
+
+// RateLimitHeaders - подстраиваться под X-RateLimit-* и Retry-After из ответов сервера
+func RateLimitHeaders() Option {
+	return func(o *options) error {
+		o.rateAdapt = true
+		return nil
+	}
+}
+
+// limited - каждая отправка по сети ждет свободный токен
+func limited(l *rateLimiter, adapt bool, next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (res Response, err error) {
+		if err = l.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		if res, err = next.Do(req); res != nil && adapt {
+			l.Observe(res)
+		}
+
+		return res, err
+	})
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// rateLimiter - корзина токенов, общая для всех запросов базового запроса
+type rateLimiter struct {
+	sync.Mutex
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	until  time.Time
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve(time.Now())
+
+		if wait <= 0 {
+			return nil
+		}
+
+		if !sleep(ctx, wait) {
+			return ErrRateLimit.WithReason(ctx.Err())
+		}
+	}
+}
+
+// reserve - забирает токен, если он есть, иначе возвращает время до его появления
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Before(l.until) {
+		return l.until.Sub(now)
+	}
+
+	if l.tokens += now.Sub(l.last).Seconds() * l.rate; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Observe - сервер сообщил, что квота исчерпана, ждем её восстановления
+func (l *rateLimiter) Observe(res Response) {
+	var until time.Time
+
+	head := res.Header()
+	now := time.Now()
+
+	if res.Code() == http.StatusTooManyRequests {
+		if wait, ok := retryAfter(head.Get(HeaderRetryAfter)); ok {
+			until = now.Add(wait)
+		}
+	}
+
+	if left, err := strconv.ParseInt(head.Get(HeaderRateRemaining), 10, 64); err == nil && left <= 0 {
+		if reset, err := strconv.ParseInt(head.Get(HeaderRateReset), 10, 64); err == nil {
+			// Сервера присылают то секунды до сброса, то момент сброса
+			if reset > 1000000000 {
+				until = time.Unix(reset, 0)
+			} else {
+				until = now.Add(time.Duration(reset) * time.Second)
+			}
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if until.After(l.until) {
+		l.until = until
+		l.tokens = 0
+	}
+}
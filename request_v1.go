@@ -3,6 +3,7 @@ package webx
 import (
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
 	"strings"
@@ -13,11 +14,26 @@ import (
 )
 
 var ErrMsgMustBeAbs = "Базовый URL должен быть абсолютным"
+var ErrMsgNoReplay = "Тело запроса не может быть прочитано повторно"
+var ErrMsgAbsRef = "Абсолютный адрес запроса не разрешен"
+var ErrMsgBaseOnly = "Параметр задается только для базового запроса"
+var ErrMsgFormFiles = "Файлы нельзя передать в форме application/x-www-form-urlencoded"
 
 var defClient = &http.Client{
 	Timeout: time.Minute,
 }
 
+// defStreamClient - общий таймаут оборвал бы чтение большого потока, поэтому ограничено только ожидание ответа
+var defStreamClient = &http.Client{
+	Transport: newStreamTransport(),
+}
+
+func newStreamTransport() http.RoundTripper {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.ResponseHeaderTimeout = time.Minute
+	return tr
+}
+
 func newRequestV1(base string, args []Option) (req *v1Request, err error) {
 	req = new(v1Request)
 
@@ -25,54 +41,109 @@ func newRequestV1(base string, args []Option) (req *v1Request, err error) {
 		return nil, ErrBadRequest.WithReason(err)
 	}
 
-	if req.base, err = url.ParseRequestURI(base); err != nil {
+	if req.base, err = parseBase(base); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func parseBase(base string) (u *url.URL, err error) {
+	// Фрагмент на сервер не отправляется и к запросу отношения не имеет
+	if i := strings.IndexByte(base, '#'); i >= 0 {
+		base = base[:i]
+	}
+
+	if u, err = url.ParseRequestURI(base); err != nil {
 		return nil, ErrBadURL.WithReason(err).WithDebug(errx.Debug{
 			"URL": base,
 		})
 	}
 
-	if !req.base.IsAbs() {
+	if !u.IsAbs() {
 		return nil, ErrBadURL.WithDetail(ErrMsgMustBeAbs).WithDebug(errx.Debug{
 			"URL": base,
 		})
 	}
 
-	return req, nil
+	return u, nil
 }
 
 type v1Request struct {
 	opts options
 	base *url.URL
+	pool *balancer
 }
 
 func (c v1Request) Make(ref string, args ...Option) (_ Response, err error) {
 	var req *http.Request
+	var addr *url.URL
 	var body io.Reader
 	var opts options
+	var getBody bodyFunc
+	var sent bool
 
 	if opts, err = getOpts(args); err != nil {
 		return nil, ErrBadRequest.WithReason(err)
 	}
 
-	if body, err = opts.Body(); err != nil {
+	// Их состояние копится между вызовами, поэтому живет только в базовом запросе
+	if opts.shared() {
+		return nil, ErrBadRequest.WithReason(ErrBadOption.WithDetail(ErrMsgBaseOnly))
+	}
+
+	if ref, err = c.expandRef(ref, &opts); err != nil {
+		return nil, ErrBadRequest.WithReason(err)
+	}
+
+	if err = hedgeable(ref, &opts); err != nil {
 		return nil, ErrBadRequest.WithReason(err)
 	}
 
-	addr := strings.TrimRight(c.base.String(), "/") + "/" + strings.TrimLeft(strings.TrimSpace(ref), "/")
+	if getBody, err = opts.Body(c.replayable(&opts)); err != nil {
+		return nil, ErrBadRequest.WithReason(err)
+	}
+
+	if getBody != nil {
+		if body, err = getBody(); err != nil {
+			return nil, ErrBadRequest.WithReason(err)
+		}
+
+		// Пока запрос не отправлен, тело наше: фоновая запись формы и открытый файл не должны пережить ошибку
+		defer func() {
+			if rc, ok := body.(io.Closer); ok && !sent {
+				rc.Close()
+			}
+		}()
+	}
+
+	if addr, err = c.resolve(c.base, ref, &opts); err != nil {
+		return nil, ErrBadRequest.WithReason(err)
+	}
 
 	if opts.ctx == nil {
-		req, err = http.NewRequest(opts.method, addr, body)
+		req, err = http.NewRequest(opts.method, addr.String(), body)
 	} else {
-		req, err = http.NewRequestWithContext(opts.ctx, opts.method, addr, body)
+		req, err = http.NewRequestWithContext(opts.ctx, opts.method, addr.String(), body)
 	}
 
 	if err != nil {
 		return nil, ErrBadRequest.WithReason(err).WithDebug(errx.Debug{
-			"URL":    addr,
+			"URL":    addr.String(),
 			"Method": opts.method,
 		})
 	}
 
+	if getBody != nil {
+		// Для повторов тело запроса будет получено заново
+		req.GetBody = getBody.ReadCloser
+	}
+
+	if opts.length > 0 {
+		// Размер потокового тела известен заранее
+		req.ContentLength = opts.length
+	}
+
 	if err = c.applyGetArgs(req, &opts); err != nil {
 		return nil, ErrBadRequest.WithReason(err)
 	}
@@ -81,9 +152,104 @@ func (c v1Request) Make(ref string, args ...Option) (_ Response, err error) {
 		return nil, ErrBadRequest.WithReason(err)
 	}
 
+	// Дальше телом распоряжается транспорт
+	sent = true
+
+	if c.pool != nil {
+		return c.failover(req, ref, &opts)
+	}
+
 	return c.do(req, &opts)
 }
 
+// resolve - полный адрес запроса относительно базового, без фрагмента
+// Аргументы базового адреса остаются, только если запрос идет на тот же сервер
+func (c v1Request) resolve(base *url.URL, ref string, opts *options) (addr *url.URL, err error) {
+	var rel *url.URL
+
+	mode := opts.resolve
+	if mode == 0 {
+		mode = c.opts.resolve
+	}
+
+	if ref = strings.TrimSpace(ref); mode == ResolveRef || absoluteRef(ref) {
+		rel, err = url.Parse(ref)
+	} else {
+		// Двоеточие в первом сегменте - часть пути, как у методов вида users:search
+		rel, err = url.Parse("/" + strings.TrimLeft(ref, "/"))
+	}
+
+	if err != nil {
+		return nil, ErrBadURL.WithReason(err).WithDebug(errx.Debug{
+			"Адрес": ref,
+		})
+	}
+
+	if rel.IsAbs() || rel.Host != "" {
+		if !opts.absolute && !c.opts.absolute {
+			return nil, ErrBadURL.WithDetail(ErrMsgAbsRef).WithDebug(errx.Debug{
+				"Адрес": ref,
+			})
+		}
+	}
+
+	switch {
+	case mode == ResolveRef, rel.IsAbs(), rel.Host != "":
+		addr = base.ResolveReference(rel)
+	default:
+		// Путь запроса всегда продолжает базовый путь
+		path := strings.TrimRight(base.EscapedPath(), "/") + "/" + strings.TrimLeft(rel.EscapedPath(), "/")
+
+		if addr, err = url.Parse(path); err != nil {
+			return nil, ErrBadURL.WithReason(err).WithDebug(errx.Debug{
+				"Адрес": ref,
+			})
+		}
+
+		addr.Scheme, addr.User, addr.Host = base.Scheme, base.User, base.Host
+	}
+
+	args := rel.Query()
+
+	if addr.Host == base.Host && addr.Scheme == base.Scheme {
+		// Аргументы самого адреса важнее базовых
+		for name, list := range base.Query() {
+			if _, ok := args[name]; !ok {
+				args[name] = list
+			}
+		}
+	}
+
+	addr.RawQuery = args.Encode()
+	addr.Fragment, addr.RawFragment = "", ""
+	return addr, nil
+}
+
+// absoluteRef - у адреса есть схема с хостом, либо он начинается с //
+func absoluteRef(ref string) bool {
+	if strings.HasPrefix(ref, "//") {
+		return true
+	}
+
+	i := strings.Index(ref, "://")
+	if i < 1 {
+		return false
+	}
+
+	for j := 0; j < i; j++ {
+		c := ref[j]
+
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case j > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
 func (c v1Request) applyGetArgs(req *http.Request, opts *options) error {
 
 	// Возможно, какие-то аргументы уже указаны в запросе
@@ -95,8 +261,8 @@ func (c v1Request) applyGetArgs(req *http.Request, opts *options) error {
 		args[name] = append(args[name], list...)
 	}
 
-	for name := range c.opts.setget {
-		args.Set(name, c.opts.setget.Get(name))
+	for name, list := range c.opts.setget {
+		args[name] = append([]string(nil), list...)
 	}
 
 	// Затем параметры из основного запроса
@@ -105,8 +271,8 @@ func (c v1Request) applyGetArgs(req *http.Request, opts *options) error {
 		args[name] = append(args[name], list...)
 	}
 
-	for name := range opts.setget {
-		args.Set(name, opts.setget.Get(name))
+	for name, list := range opts.setget {
+		args[name] = append([]string(nil), list...)
 	}
 
 	// Конвертируются обратно
@@ -138,21 +304,155 @@ func (c v1Request) applyHeaders(req *http.Request, opts *options) error {
 		req.Header.Set(HeaderContentType, MimeUnknown)
 	}
 
-	// Если этому запросу нужна авторизация - применяем её
-	if opts.user != "" {
-		req.SetBasicAuth(opts.user, opts.pass)
-		return nil
+	// Авторизация самого запроса важнее базовой
+	if auth := c.authorizer(opts); auth != nil {
+		return auth.Authorize(req)
 	}
 
-	// Если авторизация в базовом запросе - применяем её
-	if c.opts.user != "" {
-		req.SetBasicAuth(c.opts.user, c.opts.pass)
+	return nil
+}
+
+// do - внешние звенья работают один раз на весь вызов, внутренние - на каждую попытку
+func (c v1Request) do(req *http.Request, opts *options) (Response, error) {
+	var next Doer = DoerFunc(func(req *http.Request) (Response, error) { return c.retry(req, opts) })
+
+	// Дубли только у самого вызова, базовый запрос не знает, какие вызовы безопасны
+	if opts.hedgeExtra > 0 {
+		next = hedged(opts.hedgeDelay, opts.hedgeExtra, next)
 	}
 
-	return nil
+	if b := c.opts.breaker; b != nil {
+		next = guarded(b, next)
+	}
+
+	// Ожидание в очереди не считается неудачей сервиса, а ответ из кеша места не занимает
+	if b := c.opts.bulkhead; b != nil {
+		next = isolated(b, next)
+	}
+
+	if store := c.cacheStore(opts); store != nil && req.Method == http.MethodGet && !opts.stream && !c.opts.stream {
+		next = cached(store, c.responseOpts(opts), next)
+	}
+
+	// Поток нельзя раздать нескольким вызовам
+	if g := c.opts.dedup; g != nil && !opts.stream && !c.opts.stream {
+		next = deduped(g, c.responseOpts(opts), next)
+	}
+
+	return next.Do(req)
+}
+
+func (c v1Request) retry(req *http.Request, opts *options) (res Response, err error) {
+	var wait time.Duration
+
+	attempts := c.attempts(opts)
+	doer := c.chain(opts)
+
+	for i := 1; ; i++ {
+		res, err = doer.Do(req)
+
+		if i >= attempts || !c.retryable(res, err, opts) {
+			return res, err
+		}
+
+		if wait = c.backoff(i, res, opts); wait < 0 {
+			// Сервер просит подождать дольше, чем мы готовы
+			return res, err
+		}
+
+		if !sleep(req.Context(), wait) {
+			return res, err
+		}
+
+		// Если тело не повторить - остается последний результат
+		next, rerr := replay(req)
+		if rerr != nil {
+			return res, err
+		}
+
+		// Ответ больше не нужен, но поток должен быть закрыт
+		if res != nil {
+			res.Close()
+		}
+
+		req = next
+	}
 }
 
-func (c v1Request) do(req *http.Request, opts *options) (_ Response, err error) {
+// chain - перехватчики оборачивают каждую попытку, базовые снаружи, собственные внутри
+func (c v1Request) chain(opts *options) (next Doer) {
+	next = DoerFunc(func(req *http.Request) (Response, error) { return c.send(req, opts) })
+
+	// Квота тратится на каждую отправку, включая повторы
+	if l := c.opts.limiter; l != nil {
+		next = limited(l, opts.rateAdapt || c.opts.rateAdapt, next)
+	}
+
+	for i := len(opts.use) - 1; i >= 0; i-- {
+		next = opts.use[i](next)
+	}
+
+	for i := len(c.opts.use) - 1; i >= 0; i-- {
+		next = c.opts.use[i](next)
+	}
+
+	// Повтор после отказа в доступе проходит через перехватчики заново
+	if ch, ok := c.authorizer(opts).(challenger); ok {
+		next = challenge(ch, next)
+	}
+
+	return next
+}
+
+// replayable - понадобится ли отправлять тело запроса повторно
+func (c v1Request) replayable(opts *options) bool {
+	if c.attempts(opts) > 1 {
+		return true
+	}
+
+	if c.signer(opts) != nil {
+		return true
+	}
+
+	if c.pool != nil && len(c.pool.list) > 1 {
+		return true
+	}
+
+	if opts.hedgeExtra > 0 {
+		return true
+	}
+
+	_, ok := c.authorizer(opts).(challenger)
+	return ok
+}
+
+func (c v1Request) responseOpts(opts *options) responseOpts {
+	ro := responseOpts{
+		stream:   opts.stream || c.opts.stream,
+		limit:    opts.maxBody,
+		endpoint: c.base.String(),
+	}
+
+	if ro.limit == 0 {
+		ro.limit = c.opts.maxBody
+	}
+
+	if ro.classify = opts.classify; ro.classify == nil {
+		ro.classify = c.opts.classify
+	}
+
+	if ro.errBody = opts.errBody; ro.errBody == nil && c.opts.errBody != nil {
+		ro.errBody = c.opts.errBody
+		ro.errFresh = true
+	}
+
+	ro.accept = append(ro.accept, c.opts.accept...)
+	ro.accept = append(ro.accept, opts.accept...)
+
+	return ro
+}
+
+func (c v1Request) send(req *http.Request, opts *options) (_ Response, err error) {
 	var resp *http.Response
 	var client *http.Client
 
@@ -162,11 +462,21 @@ func (c v1Request) do(req *http.Request, opts *options) (_ Response, err error)
 	} else if c.opts.client != nil {
 		// Если в базовом запросе указан клиент, используем его
 		client = c.opts.client
+	} else if opts.stream || c.opts.stream {
+		// Для потока умолчания свои
+		client = defStreamClient
 	} else {
 		// Если нигде указан - используем умолчания
 		client = defClient
 	}
 
+	// Подпись последней, когда запрос уже не изменится
+	if signer := c.signer(opts); signer != nil {
+		if err = signer.Sign(req); err != nil {
+			return nil, ErrBadRequest.WithReason(err)
+		}
+	}
+
 	if c.opts.debug || opts.debug {
 		var dump []byte
 
@@ -178,6 +488,10 @@ func (c v1Request) do(req *http.Request, opts *options) (_ Response, err error)
 		glog.Flush()
 	}
 
+	// Замеряем время этапов выполнения запроса
+	trace := newTracer()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.ClientTrace()))
+
 	if resp, err = client.Do(req); err != nil {
 		return nil, ErrBadRequest.WithReason(err).WithDebug(errx.Debug{
 			"URL":    req.URL.String(),
@@ -186,5 +500,7 @@ func (c v1Request) do(req *http.Request, opts *options) (_ Response, err error)
 		})
 	}
 
-	return newResponseV1(req, resp)
+	res, err := newResponseV1(req, resp, c.responseOpts(opts))
+	res.time = trace.Timing()
+	return res, err
 }
@@ -1,49 +1,134 @@
 package webx
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"os"
 	"path"
+	"reflect"
 	"strings"
 
 	"github.com/shestakovda/errx"
 )
 
-func newResponseV1(req *http.Request, res *http.Response) (r *v1Response, err error) {
+const defErrorBody = 1 << 20
+
+var ErrMsgTooLarge = "Размер ответа превышает допустимые %d байт"
+
+// responseOpts - параметры разбора ответа, собранные из базового и основного запросов
+type responseOpts struct {
+	stream   bool
+	limit    int64
+	accept   []int
+	classify func(int) error
+	errBody  interface{}
+	errFresh bool
+	endpoint string
+}
+
+func newResponseV1(req *http.Request, res *http.Response, opts responseOpts) (r *v1Response, err error) {
 	r = &v1Response{
-		base: req,
-		head: res.Header,
-		code: res.StatusCode,
+		base:     req,
+		last:     res.Request,
+		head:     res.Header,
+		code:     res.StatusCode,
+		proto:    res.Proto,
+		size:     res.ContentLength,
+		cook:     res.Cookies(),
+		limit:    opts.limit,
+		accept:   opts.accept,
+		classify: opts.classify,
+		errBody:  opts.errBody,
+		errFresh: opts.errFresh,
+		endpoint: opts.endpoint,
 	}
 
-	if res.Body != nil {
-		defer res.Body.Close()
+	if r.last == nil {
+		r.last = req
+	}
 
-		if r.body, err = ioutil.ReadAll(res.Body); err != nil {
-			return r, ErrBadResponse.WithReason(err)
-		}
+	if res.Body == nil {
+		return r, r.Error()
+	}
+
+	// В потоковом режиме успешный ответ читает сам клиент
+	if opts.stream && r.Error() == nil {
+		r.stream = res.Body
+		return r, nil
+	}
+
+	defer res.Body.Close()
+
+	// Тело ошибки в потоковом режиме нужно для отладки, но не целиком
+	if opts.stream && (r.limit <= 0 || r.limit > defErrorBody) {
+		r.limit = defErrorBody
+		r.body, err = ioutil.ReadAll(io.LimitReader(res.Body, r.limit))
+	} else {
+		r.body, err = r.readAll(res.Body)
+	}
+
+	if err != nil {
+		r.partial = true
+		return r, err
 	}
 
 	return r, r.Error()
 }
 
 type v1Response struct {
-	code int
-	body []byte
-	head http.Header
-	base *http.Request
+	code   int
+	size   int64
+	limit  int64
+	body   []byte
+	time   Timing
+	proto  string
+	head   http.Header
+	cook   []*http.Cookie
+	base   *http.Request
+	last   *http.Request
+	stream io.ReadCloser
+	cached bool
+
+	// partial - тело прочитано не полностью из-за ошибки или ограничения размера
+	partial bool
+
+	accept   []int
+	classify func(int) error
+	errBody  interface{}
+	errFresh bool
+	endpoint string
+	hedges   int
 }
 
-func (r v1Response) URL() string  { return r.base.URL.String() }
-func (r v1Response) Code() int    { return r.code }
-func (r v1Response) Body() []byte { return r.body }
-func (r v1Response) Text() string { return string(r.body) }
-func (r v1Response) File() (_ *File, err error) {
+func (r *v1Response) URL() string  { return r.base.URL.String() }
+func (r *v1Response) Code() int    { return r.code }
+func (r *v1Response) Body() []byte { r.load(); return r.body }
+func (r *v1Response) Text() string { r.load(); return string(r.body) }
+
+func (r *v1Response) Header() http.Header     { return r.head }
+func (r *v1Response) Cookies() []*http.Cookie { return r.cook }
+func (r *v1Response) Proto() string           { return r.proto }
+func (r *v1Response) ContentLength() int64    { return r.size }
+func (r *v1Response) FinalURL() string        { return r.last.URL.String() }
+func (r *v1Response) Timing() Timing          { return r.time }
+func (r *v1Response) Cached() bool            { return r.cached }
+func (r *v1Response) Endpoint() string        { return r.endpoint }
+func (r *v1Response) Hedges() int             { return r.hedges }
+
+func (r *v1Response) File() (_ *File, err error) {
 	var cdh map[string]string
 
+	if err = r.load(); err != nil {
+		return nil, err
+	}
+
+	data := r.body
+
 	if disp := r.head.Get(HeaderContentDisp); disp != "" {
 		if _, cdh, err = mime.ParseMediaType(disp); err != nil {
 			return nil, ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
@@ -58,23 +143,35 @@ func (r v1Response) File() (_ *File, err error) {
 	if strings.EqualFold(r.head.Get(HeaderContentEnc), "base64") {
 		var n int
 
-		buf := make([]byte, base64.StdEncoding.DecodedLen(len(r.body)))
-		if n, err = base64.StdEncoding.Decode(buf, r.body); err != nil {
+		buf := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+		if n, err = base64.StdEncoding.Decode(buf, data); err != nil {
 			return nil, ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
 				"Значение":  r.body,
 				"Заголовки": r.head,
 			})
 		}
-		r.body = buf[:n]
+		data = buf[:n]
 	}
 
 	return &File{
 		Name: cdh["filename"],
 		Mime: r.head.Get(HeaderContentType),
-		Data: r.body,
+		Data: data,
 	}, nil
 }
-func (r v1Response) JSON(item interface{}) (err error) {
+func (r *v1Response) JSON(item interface{}) (err error) {
+	if r.stream != nil {
+		// Большой поток разбираем на лету, не загружая в память
+		defer r.Close()
+
+		if err = json.NewDecoder(r.stream).Decode(item); err != nil {
+			return ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
+				"URL": r.base.URL.String(),
+			})
+		}
+		return nil
+	}
+
 	if err = json.Unmarshal(r.body, item); err != nil {
 		return ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
 			"Ответ": string(r.body),
@@ -83,27 +180,212 @@ func (r v1Response) JSON(item interface{}) (err error) {
 
 	return nil
 }
-func (r v1Response) Error() error {
-	var err errx.Error
+func (r *v1Response) Error() error {
+	var reason error
 
-	switch r.code {
-	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent, http.StatusNotModified:
+	for i := range r.accept {
+		if r.accept[i] == r.code {
+			return nil
+		}
+	}
+
+	if r.classify != nil {
+		reason = r.classify(r.code)
+	} else {
+		reason = DefaultClassifier(r.code)
+	}
+
+	if reason == nil {
 		return nil
-	case http.StatusNotFound:
-		err = ErrResponse.WithReason(errx.ErrNotFound)
-	case http.StatusForbidden:
-		err = ErrResponse.WithReason(errx.ErrForbidden)
-	case http.StatusUnauthorized:
-		err = ErrResponse.WithReason(errx.ErrUnauthorized)
-	case http.StatusBadRequest, http.StatusMethodNotAllowed:
-		err = ErrResponse.WithReason(errx.ErrBadRequest)
-	default:
-		err = ErrResponse.WithReason(errx.ErrUnavailable)
 	}
 
-	return err.WithDebug(errx.Debug{
+	// Если тело ошибки разобрано - оно едет вместе с ошибкой
+	if body, prob := r.errorBody(); body != nil || prob != nil {
+		reason = &ResponseError{
+			Code:    r.code,
+			Body:    body,
+			Problem: prob,
+			reason:  reason,
+		}
+	}
+
+	return ErrResponse.WithReason(reason).WithDebug(errx.Debug{
 		"Код":   r.code,
 		"URL":   r.base.URL.String(),
 		"Ответ": string(r.body),
 	})
 }
+
+// DefaultClassifier - стандартное сопоставление кода ответа и ошибки, nil для успешных кодов
+func DefaultClassifier(code int) error {
+	switch {
+	case code >= 200 && code < 300, code == http.StatusNotModified:
+		return nil
+	}
+
+	switch code {
+	case http.StatusNotFound:
+		return errx.ErrNotFound
+	case http.StatusForbidden:
+		return errx.ErrForbidden
+	case http.StatusUnauthorized:
+		return errx.ErrUnauthorized
+	case http.StatusBadRequest:
+		return errx.ErrBadRequest
+	case http.StatusMethodNotAllowed:
+		return errx.ErrBadRequest.WithReason(errx.ErrNotAllowed)
+	case http.StatusNotAcceptable:
+		return errx.ErrBadRequest.WithReason(errx.ErrNotAcceptable)
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusUnprocessableEntity:
+		return ErrValidation.WithReason(errx.ErrUnprocessable)
+	case http.StatusTooManyRequests:
+		return ErrRateLimit
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	case http.StatusInternalServerError:
+		return ErrServer.WithReason(errx.ErrInternal)
+	case http.StatusNotImplemented:
+		return ErrServer.WithReason(errx.ErrNotImplemented)
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return ErrServer.WithReason(errx.ErrUnavailable)
+	}
+
+	switch {
+	case code >= 500:
+		return ErrServer
+	case code >= 400:
+		return errx.ErrBadRequest
+	case code >= 300:
+		// Перенаправление, которое клиент не выполнил, - не отказ сервиса
+		return ErrRedirect
+	}
+
+	// Информационный код не может быть итоговым ответом
+	return ErrBadResponse
+}
+
+// readAll - чтение тела целиком, но не больше разрешенного размера
+func (r *v1Response) readAll(body io.Reader) (buf []byte, err error) {
+	if r.limit <= 0 {
+		if buf, err = ioutil.ReadAll(body); err != nil {
+			return nil, ErrBadResponse.WithReason(err)
+		}
+		return buf, nil
+	}
+
+	if buf, err = ioutil.ReadAll(io.LimitReader(body, r.limit+1)); err != nil {
+		return nil, ErrBadResponse.WithReason(err)
+	}
+
+	if int64(len(buf)) > r.limit {
+		return nil, ErrBadResponse.WithDetail(ErrMsgTooLarge, r.limit).WithDebug(errx.Debug{
+			"Код": r.code,
+			"URL": r.base.URL.String(),
+		})
+	}
+
+	return buf, nil
+}
+
+// load - вычитывание потока в память, если к телу обращаются как к буферу
+func (r *v1Response) load() (err error) {
+	if r.stream == nil {
+		return nil
+	}
+
+	defer r.Close()
+	r.body, err = r.readAll(r.stream)
+	return err
+}
+
+func (r *v1Response) Reader() io.ReadCloser {
+	if r.stream != nil {
+		return r.stream
+	}
+	return ioutil.NopCloser(bytes.NewReader(r.body))
+}
+
+func (r *v1Response) Close() (err error) {
+	if r.stream == nil {
+		return nil
+	}
+
+	err = r.stream.Close()
+	r.stream = nil
+	return err
+}
+
+func (r *v1Response) WriteTo(w io.Writer) (n int64, err error) {
+	if r.stream == nil {
+		if n, err = io.Copy(w, bytes.NewReader(r.body)); err != nil {
+			return n, ErrBadResponse.WithReason(err)
+		}
+		return n, nil
+	}
+
+	defer r.Close()
+
+	if n, err = io.Copy(w, r.stream); err != nil {
+		return n, ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
+			"URL":       r.base.URL.String(),
+			"Прочитано": n,
+		})
+	}
+
+	return n, nil
+}
+
+func (r *v1Response) SaveAs(name string) (n int64, err error) {
+	var file *os.File
+
+	if file, err = os.Create(name); err != nil {
+		return 0, ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
+			"Файл": name,
+		})
+	}
+
+	if n, err = r.WriteTo(file); err != nil {
+		file.Close()
+		return n, err
+	}
+
+	if err = file.Close(); err != nil {
+		return n, ErrBadResponse.WithReason(err).WithDebug(errx.Debug{
+			"Файл": name,
+		})
+	}
+
+	return n, nil
+}
+
+// errorBody - разбор тела ответа с ошибкой в структуру клиента и в описание проблемы
+func (r *v1Response) errorBody() (body interface{}, prob *Problem) {
+	if len(r.body) == 0 {
+		return nil, nil
+	}
+
+	if mt, _, err := mime.ParseMediaType(r.head.Get(HeaderContentType)); err == nil && mt == MimeProblem {
+		prob = new(Problem)
+
+		if err = json.Unmarshal(r.body, prob); err != nil {
+			prob = nil
+		}
+	}
+
+	if r.errBody == nil {
+		return nil, prob
+	}
+
+	if body = r.errBody; r.errFresh {
+		// Цель из базового запроса общая, поэтому каждый ответ разбирается в свою копию
+		body = reflect.New(reflect.TypeOf(r.errBody).Elem()).Interface()
+	}
+
+	if err := json.Unmarshal(r.body, body); err != nil {
+		return nil, prob
+	}
+
+	return body, prob
+}
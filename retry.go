@@ -0,0 +1,156 @@
+package webx
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defRetryDelay = 100 * time.Millisecond
+	defRetryLimit = 10 * time.Second
+)
+
+// attempts - общее количество попыток выполнения запроса
+func (c v1Request) attempts(opts *options) int {
+	if opts.retries > 0 {
+		return opts.retries
+	}
+
+	if c.opts.retries > 0 {
+		return c.opts.retries
+	}
+
+	return 1
+}
+
+func (c v1Request) retryable(res Response, err error, opts *options) bool {
+	var code int
+
+	if res != nil {
+		code = res.Code()
+	}
+
+	if opts.retryIf != nil {
+		return opts.retryIf(code, err)
+	}
+
+	if c.opts.retryIf != nil {
+		return c.opts.retryIf(code, err)
+	}
+
+	return defRetryIf(code, err)
+}
+
+// backoff - пауза перед следующей попыткой, отрицательная если повторять не нужно
+func (c v1Request) backoff(attempt int, res Response, opts *options) time.Duration {
+	delay, limit := defRetryDelay, defRetryLimit
+
+	if opts.retries > 0 {
+		delay, limit = opts.delay, opts.maxDelay
+	} else if c.opts.retries > 0 {
+		delay, limit = c.opts.delay, c.opts.maxDelay
+	}
+
+	// Если сервер сам сказал, когда приходить - слушаемся
+	if res != nil {
+		if code := res.Code(); code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+			if wait, ok := retryAfter(res.Header().Get(HeaderRetryAfter)); ok {
+				if wait > limit {
+					return -1
+				}
+				return wait
+			}
+		}
+	}
+
+	// Экспоненциальный рост с ограничением сверху
+	wait := limit
+	if attempt < 32 && delay<<uint(attempt-1) < limit {
+		wait = delay << uint(attempt-1)
+	}
+
+	// Половина паузы фиксирована, половина случайна - чтобы клиенты не ходили строем
+	if half := int64(wait / 2); half > 0 {
+		wait = time.Duration(half + rand.Int63n(half+1))
+	}
+
+	return wait
+}
+
+// defRetryIf - по умолчанию повторяем сетевые ошибки и временные ответы сервера
+func defRetryIf(code int, err error) bool {
+	if code == 0 {
+		return err != nil && !errors.Is(err, context.Canceled)
+	}
+
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+
+	return code >= http.StatusInternalServerError
+}
+
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if sec, err := strconv.Atoi(value); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// sleep - пауза с учетом контекста, false если контекст завершился раньше
+func sleep(ctx context.Context, wait time.Duration) bool {
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// replay - копия запроса для повторной отправки, со свежим телом
+func replay(req *http.Request) (_ *http.Request, err error) {
+	next := req.Clone(req.Context())
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, nil
+	}
+
+	if req.GetBody == nil {
+		return nil, ErrBadBody.WithDetail(ErrMsgNoReplay)
+	}
+
+	if next.Body, err = req.GetBody(); err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
@@ -0,0 +1,303 @@
+package webx
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AuthHMAC  = "HMAC-SHA256"
+	AuthSigV4 = "AWS4-HMAC-SHA256"
+
+	// UnsignedPayload - хеш тела для SigV4, когда тело в подпись не входит
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// emptySHA256 - хеш пустого тела
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// Signer - подпись запроса непосредственно перед отправкой, после всех заголовков и аргументов
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+type SignerFunc func(req *http.Request) error
+
+func (f SignerFunc) Sign(req *http.Request) error { return f(req) }
+
+func Sign(s Signer) Option {
+	return func(o *options) error {
+		if s == nil {
+			return ErrBadOption.WithStack()
+		}
+
+		o.signer = s
+		return nil
+	}
+}
+
+func (c v1Request) signer(opts *options) Signer {
+	if opts.signer != nil {
+		return opts.signer
+	}
+
+	return c.opts.signer
+}
+
+// BodySHA256 - хеш тела запроса в hex, тело читается из повторяемого источника и не расходуется
+func BodySHA256(req *http.Request) (_ string, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return emptySHA256, nil
+	}
+
+	sum := sha256.New()
+
+	if err = copyBody(sum, req); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// copyBody - пишет в w отдельную копию тела запроса, само тело запроса не читается и не закрывается
+func copyBody(w io.Writer, req *http.Request) (err error) {
+	var body io.ReadCloser
+
+	if req.GetBody == nil {
+		return ErrBadBody.WithDetail(ErrMsgNoReplay)
+	}
+
+	// GetBody каждый раз отдает новый читатель: отрезок файла, буфер или поток формы.
+	// Закрывается только он, исходный файл и живое тело остаются нетронутыми
+	if body, err = req.GetBody(); err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if _, err = io.Copy(w, body); err != nil {
+		return ErrBadBody.WithReason(err)
+	}
+
+	return nil
+}
+
+// HMACSigner - подпись HMAC-SHA256 от метода, пути, аргументов, выбранных заголовков и хеша тела
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+
+	// Headers - заголовки, входящие в подпись
+	Headers []string
+
+	// Header - куда положить подпись, по умолчанию Authorization
+	Header string
+}
+
+func (s *HMACSigner) Sign(req *http.Request) (err error) {
+	var digest string
+
+	if digest, err = BodySHA256(req); err != nil {
+		return err
+	}
+
+	req.Header.Set(HeaderContentSHA256, digest)
+
+	names := make([]string, len(s.Headers))
+	for i := range s.Headers {
+		names[i] = strings.ToLower(s.Headers[i])
+	}
+	sort.Strings(names)
+
+	buf := new(strings.Builder)
+	buf.WriteString(req.Method + "\n")
+	buf.WriteString(req.URL.EscapedPath() + "\n")
+	buf.WriteString(req.URL.Query().Encode() + "\n")
+
+	for i := range names {
+		buf.WriteString(names[i] + ":" + canonicalValue(req, names[i]) + "\n")
+	}
+
+	buf.WriteString(digest)
+
+	value := AuthHMAC + " KeyId=" + s.KeyID +
+		", SignedHeaders=" + strings.Join(names, ";") +
+		", Signature=" + hex.EncodeToString(hmacSum(s.Secret, buf.String()))
+
+	if s.Header == "" {
+		req.Header.Set(HeaderAuthorization, value)
+	} else {
+		req.Header.Set(s.Header, value)
+	}
+
+	return nil
+}
+
+// SigV4Signer - подпись AWS Signature Version 4
+type SigV4Signer struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
+
+	// Unsigned - не включать тело в подпись, если его дорого читать повторно
+	Unsigned bool
+
+	// Now - источник времени подписи, по умолчанию текущее время
+	Now func() time.Time
+}
+
+// sigV4Skip - заголовки, которые могут измениться по дороге и в подпись не входят
+var sigV4Skip = map[string]bool{
+	"authorization":     true,
+	"user-agent":        true,
+	"x-amzn-trace-id":   true,
+	"expect":            true,
+	"transfer-encoding": true,
+}
+
+func (s *SigV4Signer) Sign(req *http.Request) (err error) {
+	var digest string
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	ts := now().UTC()
+	date := ts.Format("20060102")
+
+	if s.Unsigned {
+		digest = UnsignedPayload
+	} else if digest, err = BodySHA256(req); err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Amz-Date", ts.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", digest)
+
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	names := []string{"host"}
+	for name := range req.Header {
+		if name = strings.ToLower(name); !sigV4Skip[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	canon := new(strings.Builder)
+	canon.WriteString(req.Method + "\n")
+	canon.WriteString(s.canonicalPath(req.URL) + "\n")
+	canon.WriteString(canonicalQuery(req.URL.Query()) + "\n")
+
+	for i := range names {
+		if names[i] == "host" {
+			canon.WriteString("host:" + host + "\n")
+		} else {
+			canon.WriteString(names[i] + ":" + canonicalValue(req, names[i]) + "\n")
+		}
+	}
+
+	signed := strings.Join(names, ";")
+	canon.WriteString("\n" + signed + "\n" + digest)
+
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canon.String()))
+	toSign := AuthSigV4 + "\n" + ts.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSum([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSum(key, s.Region)
+	key = hmacSum(key, s.Service)
+	key = hmacSum(key, "aws4_request")
+
+	req.Header.Set(HeaderAuthorization, AuthSigV4+
+		" Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+
+		", Signature="+hex.EncodeToString(hmacSum(key, toSign)))
+
+	return nil
+}
+
+// canonicalPath - путь кодируется однократно для S3 и дважды для остальных сервисов
+func (s *SigV4Signer) canonicalPath(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+
+	parts := strings.Split(u.Path, "/")
+	for i := range parts {
+		if parts[i] = awsEscape(parts[i]); s.Service != "s3" {
+			parts[i] = awsEscape(parts[i])
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func canonicalQuery(args url.Values) string {
+	list := make([]string, 0, len(args))
+
+	for name, values := range args {
+		for i := range values {
+			list = append(list, awsEscape(name)+"="+awsEscape(values[i]))
+		}
+	}
+
+	sort.Strings(list)
+	return strings.Join(list, "&")
+}
+
+// canonicalValue - значения заголовка без лишних пробелов, через запятую
+func canonicalValue(req *http.Request, name string) string {
+	values := req.Header.Values(name)
+	list := make([]string, len(values))
+
+	for i := range values {
+		list[i] = strings.Join(strings.Fields(values[i]), " ")
+	}
+
+	return strings.Join(list, ",")
+}
+
+// awsEscape - кодирование по RFC 3986, где не экранируются только A-Z a-z 0-9 - _ . ~
+func awsEscape(s string) string {
+	const hexChars = "0123456789ABCDEF"
+
+	buf := new(strings.Builder)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			buf.WriteByte(c)
+			continue
+		}
+
+		buf.WriteByte('%')
+		buf.WriteByte(hexChars[c>>4])
+		buf.WriteByte(hexChars[c&15])
+	}
+
+	return buf.String()
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, data)
+	return mac.Sum(nil)
+}
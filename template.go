@@ -0,0 +1,142 @@
+package webx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shestakovda/errx"
+)
+
+var ErrMsgNoParam = "Не задано значение параметра пути %s"
+var ErrMsgUnusedParam = "Параметр пути %s не используется в адресе"
+var ErrMsgBadTemplate = "Некорректное выражение в шаблоне адреса"
+
+// PathParam - значение переменной шаблона адреса вида /users/{id}
+// Поддерживаются {var} и {+var} из RFC 6570, значение экранируется.
+// Фрагмент на сервер не уходит, поэтому {#var} считается ошибкой шаблона
+func PathParam(name string, value interface{}) Option {
+	return func(o *options) error {
+		if !validVarName(name) {
+			return ErrBadOption.WithStack()
+		}
+
+		o.params[name] = fmt.Sprint(value)
+		return nil
+	}
+}
+
+// expandRef - подстановка параметров пути, собственные параметры запроса важнее базовых
+// Неиспользованный параметр базового запроса не ошибка, ведь он общий для разных адресов
+func (c v1Request) expandRef(ref string, opts *options) (string, error) {
+	if !strings.ContainsAny(ref, "{}") {
+		for name := range opts.params {
+			return "", ErrBadURL.WithDetail(ErrMsgUnusedParam, name).WithDebug(errx.Debug{
+				"Адрес": ref,
+			})
+		}
+
+		return ref, nil
+	}
+
+	var buf strings.Builder
+
+	used := make(map[string]bool, len(opts.params))
+
+	for s := ref; s != ""; {
+		i := strings.IndexAny(s, "{}")
+		if i < 0 {
+			buf.WriteString(s)
+			break
+		}
+
+		j := strings.IndexByte(s[i:], '}')
+		if s[i] == '}' || j < 0 {
+			return "", ErrBadURL.WithDetail(ErrMsgBadTemplate).WithDebug(errx.Debug{
+				"Адрес": ref,
+			})
+		}
+
+		buf.WriteString(s[:i])
+		expr := s[i+1 : i+j]
+		s = s[i+j+1:]
+
+		op := byte(0)
+		if expr != "" && expr[0] == '+' {
+			op, expr = expr[0], expr[1:]
+		}
+
+		if !validVarName(expr) {
+			return "", ErrBadURL.WithDetail(ErrMsgBadTemplate).WithDebug(errx.Debug{
+				"Адрес":     ref,
+				"Выражение": expr,
+			})
+		}
+
+		val, ok := opts.params[expr]
+		if ok {
+			used[expr] = true
+		} else if val, ok = c.opts.params[expr]; !ok {
+			return "", ErrBadURL.WithDetail(ErrMsgNoParam, expr).WithDebug(errx.Debug{
+				"Адрес": ref,
+			})
+		}
+
+		if op == 0 {
+			buf.WriteString(awsEscape(val))
+		} else {
+			buf.WriteString(reservedEscape(val))
+		}
+	}
+
+	for name := range opts.params {
+		if !used[name] {
+			return "", ErrBadURL.WithDetail(ErrMsgUnusedParam, name).WithDebug(errx.Debug{
+				"Адрес": ref,
+			})
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// reservedEscape - для {+var} зарезервированные символы и готовые %XX остаются как есть
+func reservedEscape(val string) string {
+	const hexChars = "0123456789ABCDEF"
+
+	buf := new(strings.Builder)
+
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+
+		switch {
+		case validVarName(val[i : i+1]), strings.IndexByte("-~:/?#[]@!$&'()*+,;=", c) >= 0:
+			buf.WriteByte(c)
+		case c == '%' && i+2 < len(val) && isHex(val[i+1]) && isHex(val[i+2]):
+			buf.WriteString(val[i : i+3])
+			i += 2
+		default:
+			buf.WriteByte('%')
+			buf.WriteByte(hexChars[c>>4])
+			buf.WriteByte(hexChars[c&15])
+		}
+	}
+
+	return buf.String()
+}
+
+// validVarName - имя переменной из латинских букв, цифр, подчеркиваний и точек
+func validVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHex(c byte) bool { return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' }
@@ -0,0 +1,73 @@
+package webx
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+func newTracer() *tracer {
+	return &tracer{
+		start: time.Now(),
+	}
+}
+
+// tracer - сбор длительности этапов запроса, события могут приходить из разных горутин
+type tracer struct {
+	sync.Mutex
+
+	time  Timing
+	start time.Time
+	dns   time.Time
+	conn  time.Time
+	tls   time.Time
+}
+
+func (t *tracer) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.Lock()
+			defer t.Unlock()
+			t.dns = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.Lock()
+			defer t.Unlock()
+			t.time.DNS += time.Since(t.dns)
+		},
+		ConnectStart: func(string, string) {
+			t.Lock()
+			defer t.Unlock()
+			t.conn = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			t.Lock()
+			defer t.Unlock()
+			t.time.Connect += time.Since(t.conn)
+		},
+		TLSHandshakeStart: func() {
+			t.Lock()
+			defer t.Unlock()
+			t.tls = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.Lock()
+			defer t.Unlock()
+			t.time.TLS += time.Since(t.tls)
+		},
+		GotFirstResponseByte: func() {
+			t.Lock()
+			defer t.Unlock()
+			t.time.FirstByte = time.Since(t.start)
+		},
+	}
+}
+
+// Timing - итоговые замеры, вызывается после чтения ответа
+func (t *tracer) Timing() Timing {
+	t.Lock()
+	defer t.Unlock()
+	t.time.Total = time.Since(t.start)
+	return t.time
+}
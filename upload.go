@@ -0,0 +1,98 @@
+package webx
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/shestakovda/errx"
+)
+
+// Upload - файл для потоковой отправки, содержимое читается только в момент отправки запроса
+type Upload struct {
+	Name string
+	Mime string
+
+	once bool
+	size func() (int64, error)
+	open func() (io.ReadCloser, error)
+}
+
+// UploadReader - файл из потока, size < 0 если размер неизвестен. Поток можно прочитать только однажды
+func UploadReader(name, mime string, r io.Reader, size int64) *Upload {
+	return &Upload{
+		Name: name,
+		Mime: mime,
+		once: true,
+		size: func() (int64, error) { return size, nil },
+		open: func() (io.ReadCloser, error) { return ioutil.NopCloser(r), nil },
+	}
+}
+
+// UploadPath - файл с диска, открывается заново при каждой отправке
+func UploadPath(name, mime string) *Upload {
+	return &Upload{
+		Name: filepath.Base(name),
+		Mime: mime,
+		size: func() (int64, error) {
+			info, err := os.Stat(name)
+			if err != nil {
+				return 0, ErrBadBody.WithReason(err).WithDebug(errx.Debug{
+					"Файл": name,
+				})
+			}
+			return info.Size(), nil
+		},
+		open: func() (io.ReadCloser, error) {
+			file, err := os.Open(name)
+			if err != nil {
+				return nil, ErrBadBody.WithReason(err).WithDebug(errx.Debug{
+					"Файл": name,
+				})
+			}
+			return file, nil
+		},
+	}
+}
+
+// UploadFS - файл из файловой системы, открывается заново при каждой отправке
+func UploadFS(fsys fs.FS, name, mime string) *Upload {
+	return &Upload{
+		Name: path.Base(name),
+		Mime: mime,
+		size: func() (int64, error) {
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				return 0, ErrBadBody.WithReason(err).WithDebug(errx.Debug{
+					"Файл": name,
+				})
+			}
+			return info.Size(), nil
+		},
+		open: func() (io.ReadCloser, error) {
+			file, err := fsys.Open(name)
+			if err != nil {
+				return nil, ErrBadBody.WithReason(err).WithDebug(errx.Debug{
+					"Файл": name,
+				})
+			}
+			return file, nil
+		},
+	}
+}
+
+// uploadFile - файл, уже целиком находящийся в памяти
+func uploadFile(file *File) *Upload {
+	data := file.Data
+
+	return &Upload{
+		Name: file.Name,
+		Mime: file.Mime,
+		size: func() (int64, error) { return int64(len(data)), nil },
+		open: func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(data)), nil },
+	}
+}